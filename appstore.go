@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AppStoreConfig lists the Mac App Store apps to install and which installed
+// apps to upgrade.
+type AppStoreConfig struct {
+	Apps    []AppStoreApp   `yaml:"apps,omitempty" json:"apps,omitempty" toml:"apps,omitempty"`
+	Upgrade AppStoreUpgrade `yaml:"upgrade,omitempty" json:"upgrade,omitempty" toml:"upgrade,omitempty"`
+}
+
+// AppStoreUpgrade is the App Store upgrade policy: "none" only reports
+// outdated apps, "listed" upgrades the apps in the config and "all" upgrades
+// every outdated app. The default is "none".
+type AppStoreUpgrade string
+
+const (
+	appStoreUpgradeNone   AppStoreUpgrade = "none"
+	appStoreUpgradeListed AppStoreUpgrade = "listed"
+	appStoreUpgradeAll    AppStoreUpgrade = "all"
+)
+
+func (u *AppStoreUpgrade) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	switch policy := AppStoreUpgrade(value); policy {
+	case appStoreUpgradeNone, appStoreUpgradeListed, appStoreUpgradeAll:
+		*u = policy
+		return nil
+	}
+	return fmt.Errorf("unknown App Store upgrade policy %q (use none, listed or all)", value)
+}
+
+// AppStoreApp is a Mac App Store entry. It is written either as a bare ID, as
+// an app name, or as a mapping with both. Entries without an ID are resolved
+// with `mas search` at install time.
+type AppStoreApp struct {
+	ID   string `yaml:"id,omitempty" json:"id,omitempty" toml:"id,omitempty"`
+	Name string `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+}
+
+var appStoreIDPattern = regexp.MustCompile(`^\d+$`)
+
+func (a *AppStoreApp) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		value := strings.TrimSpace(node.Value)
+		if value == "" {
+			return fmt.Errorf("App Store entry needs an id or a name")
+		}
+		if appStoreIDPattern.MatchString(value) {
+			a.ID = value
+		} else {
+			a.Name = value
+		}
+		return nil
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			switch key.Value {
+			case "id":
+				a.ID = strings.TrimSpace(value.Value)
+				if !appStoreIDPattern.MatchString(a.ID) {
+					return fmt.Errorf("App Store id %q must be numeric", value.Value)
+				}
+			case "name":
+				a.Name = strings.TrimSpace(value.Value)
+			default:
+				return fmt.Errorf("unknown key %q in App Store entry (use id and name)", key.Value)
+			}
+		}
+		if a.ID == "" && a.Name == "" {
+			return fmt.Errorf("App Store entry needs an id or a name")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("App Store entry must be an id, a name or a mapping with id and name")
+}
+
+// marshalValue returns the shortest form of the entry: a number for an ID,
+// a string for a name, or the full mapping when both are set.
+func (a AppStoreApp) marshalValue() interface{} {
+	if a.Name == "" {
+		if id, err := strconv.ParseInt(a.ID, 10, 64); err == nil {
+			return id
+		}
+	}
+	if a.ID == "" {
+		return a.Name
+	}
+	return map[string]string{"id": a.ID, "name": a.Name}
+}
+
+func (a AppStoreApp) MarshalYAML() (interface{}, error) {
+	return a.marshalValue(), nil
+}
+
+func (a AppStoreApp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.marshalValue())
+}
+
+func (a AppStoreApp) MarshalTOML() ([]byte, error) {
+	switch v := a.marshalValue().(type) {
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case string:
+		return []byte(strconv.Quote(v)), nil
+	}
+	return []byte(fmt.Sprintf("{ id = %s, name = %s }", strconv.Quote(a.ID), strconv.Quote(a.Name))), nil
+}
+
+func (a AppStoreApp) String() string {
+	switch {
+	case a.Name == "":
+		return a.ID
+	case a.ID == "":
+		return a.Name
+	}
+	return fmt.Sprintf("%s (%s)", a.Name, a.ID)
+}
+
+// App Store install outcomes reported per app.
+const (
+	appStoreInstalled      = "installed"
+	appStoreAlreadyPresent = "already present"
+	appStoreNotPurchased   = "not purchased"
+	appStoreNotFound       = "not found"
+	appStoreNotSignedIn    = "skipped, not signed in"
+	appStoreFailed         = "failed"
+)
+
+// appStoreResult records what happened to one App Store entry.
+type appStoreResult struct {
+	App    AppStoreApp
+	Status string
+	Detail string
+}
+
+func (r appStoreResult) String() string {
+	if r.Detail != "" {
+		return fmt.Sprintf("%s: %s (%s)", r.App, r.Status, r.Detail)
+	}
+	return fmt.Sprintf("%s: %s", r.App, r.Status)
+}
+
+// masListing is one line of `mas search`, `mas list` or `mas outdated`.
+type masListing struct {
+	ID      string
+	Name    string
+	Version string
+}
+
+var masLinePattern = regexp.MustCompile(`^\s*(\d+)\s+(.+?)\s+\(([^)]*)\)\s*$`)
+
+// parseMasListing parses the "<id>  <name>  (<version>)" lines that mas
+// prints for search results and installed apps.
+func parseMasListing(output string) []masListing {
+	var listings []masListing
+	for _, line := range strings.Split(output, "\n") {
+		m := masLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		listings = append(listings, masListing{ID: m[1], Name: strings.TrimSpace(m[2]), Version: m[3]})
+	}
+	return listings
+}
+
+// masSignedIn reports whether the user is signed in to the App Store. The
+// second result is false when mas cannot tell, which is the case on macOS
+// versions where `mas account` is no longer supported.
+func masSignedIn(r Runner) (signedIn bool, known bool) {
+	out, err := r.Output("mas", "account")
+	text := strings.ToLower(out)
+	if err != nil {
+		text += " " + strings.ToLower(err.Error())
+	}
+
+	switch {
+	case strings.Contains(text, "not signed in"):
+		return false, true
+	case err == nil && strings.Contains(out, "@"):
+		return true, true
+	}
+	return false, false
+}
+
+// resolveAppStoreApp fills in the ID of an entry given only by name. Only an
+// exact (case-insensitive) name match is accepted so a search never installs
+// a different app by accident.
+func resolveAppStoreApp(r Runner, app AppStoreApp) (AppStoreApp, error) {
+	if app.ID != "" {
+		return app, nil
+	}
+
+	out, err := r.Output("mas", "search", app.Name)
+	if err != nil {
+		return app, fmt.Errorf("mas search failed: %v", err)
+	}
+
+	listings := parseMasListing(out)
+	for _, listing := range listings {
+		if strings.EqualFold(listing.Name, app.Name) {
+			app.ID = listing.ID
+			return app, nil
+		}
+	}
+
+	if len(listings) == 0 {
+		return app, fmt.Errorf("no App Store results for %q", app.Name)
+	}
+
+	var candidates []string
+	for i, listing := range listings {
+		if i == 5 {
+			break
+		}
+		candidates = append(candidates, fmt.Sprintf("%s (%s)", listing.Name, listing.ID))
+	}
+	return app, fmt.Errorf("no exact match for %q, did you mean one of: %s", app.Name, strings.Join(candidates, ", "))
+}
+
+// installAppStoreApp installs one app and classifies the outcome.
+func installAppStoreApp(r Runner, app AppStoreApp, installed map[string]bool) appStoreResult {
+	app, err := resolveAppStoreApp(r, app)
+	if err != nil {
+		return appStoreResult{App: app, Status: appStoreNotFound, Detail: err.Error()}
+	}
+
+	if installed[app.ID] {
+		return appStoreResult{App: app, Status: appStoreAlreadyPresent}
+	}
+
+	fmt.Printf("Installing %s...\n", app)
+	out, err := r.Output("mas", "install", app.ID)
+	if err == nil {
+		return appStoreResult{App: app, Status: appStoreInstalled}
+	}
+
+	text := strings.ToLower(out + " " + err.Error())
+	if strings.Contains(text, "not been purchased") || strings.Contains(text, "no downloads began") || strings.Contains(text, "not purchased") {
+		return appStoreResult{App: app, Status: appStoreNotPurchased, Detail: "get it once in the App Store app, then re-run gomacdeploy"}
+	}
+	return appStoreResult{App: app, Status: appStoreFailed, Detail: err.Error()}
+}
+
+// Install App Store Apps
+func installAppStoreApps(r Runner, apps []AppStoreApp) []appStoreResult {
+	clearScreen()
+	if len(apps) == 0 {
+		return nil
+	}
+
+	fmt.Println("Checking if mas is installed...")
+	if _, err := r.Output("mas", "--version"); err != nil {
+		fmt.Println("mas is not installed. Installing mas...")
+		if err := r.Run("brew", "install", "mas"); err != nil {
+			fmt.Printf("Error installing mas: %v\n", err)
+			return nil
+		}
+	} else {
+		fmt.Println("mas is already installed.")
+	}
+
+	var results []appStoreResult
+	signedIn, known := masSignedIn(r)
+	if known && !signedIn {
+		fmt.Println("You are not signed in to the App Store.")
+		fmt.Println("Open the App Store app, choose Store > Sign In, then run gomacdeploy again to install:")
+		for _, app := range apps {
+			fmt.Printf("  - %s\n", app)
+			results = append(results, appStoreResult{App: app, Status: appStoreNotSignedIn})
+		}
+		return results
+	}
+	if !known {
+		fmt.Println("Could not check the App Store sign-in. If installs fail, sign in to the App Store app first.")
+	}
+
+	installed := make(map[string]bool)
+	if out, err := r.Output("mas", "list"); err == nil {
+		for _, listing := range parseMasListing(out) {
+			installed[listing.ID] = true
+		}
+	}
+
+	fmt.Println("Installing Mac App Store applications...")
+	for _, app := range apps {
+		results = append(results, installAppStoreApp(r, app, installed))
+	}
+
+	fmt.Println()
+	fmt.Println("App Store results:")
+	for _, result := range results {
+		fmt.Printf("  %s\n", result)
+	}
+	return results
+}
+
+// masOutdated returns the installed apps that have an update available. The
+// version of each listing reads "installed -> available".
+func masOutdated(r Runner) ([]masListing, error) {
+	out, err := r.Output("mas", "outdated")
+	if err != nil {
+		return nil, err
+	}
+	return parseMasListing(out), nil
+}
+
+// upgradeAppStoreApps applies the upgrade policy to the outdated App Store
+// apps and returns the apps that are still outdated afterwards. Apps listed
+// by name count as listed once installAppStoreApps has resolved their ID.
+func upgradeAppStoreApps(r Runner, config AppStoreConfig, results []appStoreResult) []masListing {
+	if _, err := r.Output("mas", "--version"); err != nil {
+		return nil
+	}
+
+	fmt.Println("Checking for App Store updates...")
+	outdated, err := masOutdated(r)
+	if err != nil {
+		fmt.Printf("Error checking for App Store updates: %v\n", err)
+		return nil
+	}
+	if len(outdated) == 0 {
+		fmt.Println("All App Store apps are up to date.")
+		return nil
+	}
+
+	args := []string{"upgrade"}
+	switch config.Upgrade {
+	case appStoreUpgradeAll:
+	case appStoreUpgradeListed:
+		listed := make(map[string]bool)
+		for _, app := range config.Apps {
+			listed[app.ID] = app.ID != ""
+		}
+		for _, result := range results {
+			listed[result.App.ID] = result.App.ID != ""
+		}
+		for _, listing := range outdated {
+			if listed[listing.ID] {
+				args = append(args, listing.ID)
+			}
+		}
+		if len(args) == 1 {
+			return outdated
+		}
+	default:
+		return outdated
+	}
+
+	if signedIn, known := masSignedIn(r); known && !signedIn {
+		fmt.Println("You are not signed in to the App Store, skipping App Store upgrades.")
+		return outdated
+	}
+
+	fmt.Println("Upgrading App Store applications...")
+	if err := r.Run("mas", args...); err != nil {
+		fmt.Printf("Error upgrading App Store apps: %v\n", err)
+	}
+
+	remaining, err := masOutdated(r)
+	if err != nil {
+		fmt.Printf("Error checking for App Store updates: %v\n", err)
+		return outdated
+	}
+	return remaining
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const masSearchOutput = `   497799835  Xcode                       (16.0)
+  1581413439  Xcode Cloud Helper          (1.2)
+   640199958  Developer                   (10.6)
+`
+
+func TestDecodeAppStoreEntries(t *testing.T) {
+	content := `
+version: 3
+appStore:
+  apps:
+    - 409201541
+    - "409203825"
+    - Xcode
+    - { id: 1278508951, name: Trello }
+    - name: Bluesky Social
+  upgrade: listed
+`
+	config, err := decodeConfig([]byte(content), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	want := []AppStoreApp{
+		{ID: "409201541"},
+		{ID: "409203825"},
+		{Name: "Xcode"},
+		{ID: "1278508951", Name: "Trello"},
+		{Name: "Bluesky Social"},
+	}
+	if !reflect.DeepEqual(config.AppStore.Apps, want) {
+		t.Errorf("got %+v, want %+v", config.AppStore.Apps, want)
+	}
+	if config.AppStore.Upgrade != appStoreUpgradeListed {
+		t.Errorf("Expected upgrade policy listed, got %q", config.AppStore.Upgrade)
+	}
+}
+
+func TestDecodeAppStoreEntriesInvalid(t *testing.T) {
+	documents := []string{
+		"version: 3\nappStore:\n  apps:\n    - { id: 1, title: Pages }\n",
+		"version: 3\nappStore:\n  apps:\n    - { id: pages }\n",
+		"version: 3\nappStore:\n  apps:\n    - {}\n",
+		"version: 3\nappStore:\n  apps:\n    - [1]\n",
+		"version: 3\nappStore:\n  upgrade: everything\n",
+	}
+
+	for _, content := range documents {
+		if _, err := decodeConfig([]byte(content), formatYAML); err == nil {
+			t.Errorf("Expected error for %q, got nil", content)
+		}
+	}
+}
+
+func TestEncodeAppStoreEntriesRoundTrip(t *testing.T) {
+	want := &Config{
+		Version: currentConfigVersion,
+		AppStore: AppStoreConfig{
+			Apps:    []AppStoreApp{{ID: "409201541"}, {Name: "Xcode"}, {ID: "1278508951", Name: "Trello"}},
+			Upgrade: appStoreUpgradeAll,
+		},
+	}
+
+	for _, format := range []string{formatYAML, formatJSON, formatTOML} {
+		data, err := encodeConfig(want, format)
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", format, err)
+			continue
+		}
+		got, err := decodeConfig(data, format)
+		if err != nil {
+			t.Errorf("%s: expected no error decoding %s, got %v", format, data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestValidateAppStoreDuplicates(t *testing.T) {
+	content := "version: 3\nappStore:\n  apps:\n    - 409201541\n    - { id: 409201541, name: Pages }\n"
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != 1 || !strings.Contains(problems[0].Message, "duplicate App Store app") {
+		t.Errorf("Expected duplicate App Store app problem, got %v", problems)
+	}
+}
+
+func TestParseMasListing(t *testing.T) {
+	listings := parseMasListing(masSearchOutput + "\nNo more results\n")
+	if len(listings) != 3 {
+		t.Fatalf("Expected 3 listings, got %v", listings)
+	}
+	want := masListing{ID: "1581413439", Name: "Xcode Cloud Helper", Version: "1.2"}
+	if listings[1] != want {
+		t.Errorf("got %+v, want %+v", listings[1], want)
+	}
+}
+
+func TestResolveAppStoreApp(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"mas search xcode":   {output: masSearchOutput},
+		"mas search Xcode C": {output: masSearchOutput},
+		"mas search Nothing": {output: "No results found\n", err: errors.New("exit status 1")},
+	})
+
+	app, err := resolveAppStoreApp(r, AppStoreApp{Name: "xcode"})
+	if err != nil || app.ID != "497799835" {
+		t.Errorf("Expected Xcode to resolve to 497799835, got %+v, %v", app, err)
+	}
+
+	_, err = resolveAppStoreApp(r, AppStoreApp{Name: "Xcode C"})
+	if err == nil || !strings.Contains(err.Error(), "Xcode Cloud Helper (1581413439)") {
+		t.Errorf("Expected no exact match error listing candidates, got %v", err)
+	}
+
+	if _, err := resolveAppStoreApp(r, AppStoreApp{Name: "Nothing"}); err == nil {
+		t.Error("Expected error for unknown app, got nil")
+	}
+
+	app, err = resolveAppStoreApp(r, AppStoreApp{ID: "1"})
+	if err != nil || app.ID != "1" || len(r.calls) != 3 {
+		t.Errorf("Expected entries with an ID not to be searched, got %+v, %v, %v", app, err, r.calls)
+	}
+}
+
+func TestInstallAppStoreAppsNotSignedIn(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"mas account": {output: "Not signed in\n", err: errors.New("exit status 1")},
+	})
+
+	results := installAppStoreApps(r, []AppStoreApp{{ID: "409201541"}, {Name: "Xcode"}})
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %v", results)
+	}
+	for _, result := range results {
+		if result.Status != appStoreNotSignedIn {
+			t.Errorf("Expected %q, got %v", appStoreNotSignedIn, result)
+		}
+	}
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "mas install") || strings.HasPrefix(call, "mas search") {
+			t.Errorf("Expected nothing to be installed, got %s", call)
+		}
+	}
+}
+
+func TestInstallAppStoreAppsResults(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"mas account":           {output: "me@example.com\n"},
+		"mas list":              {output: "409201541  Pages  (14.0)\n"},
+		"mas search Xcode":      {output: masSearchOutput},
+		"mas install 640199958": {err: errors.New("exit status 1: Error: No downloads began")},
+		"mas install 111":       {err: errors.New("exit status 1: Error: network down")},
+	})
+
+	results := installAppStoreApps(r, []AppStoreApp{
+		{ID: "409201541", Name: "Pages"},
+		{Name: "Xcode"},
+		{ID: "640199958"},
+		{ID: "111"},
+		{Name: "Missing"},
+	})
+
+	want := []string{appStoreAlreadyPresent, appStoreInstalled, appStoreNotPurchased, appStoreFailed, appStoreNotFound}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %v", len(want), results)
+	}
+	for i, status := range want {
+		if results[i].Status != status {
+			t.Errorf("Result %d: expected %q, got %v", i, status, results[i])
+		}
+	}
+	if !r.called("mas install 497799835") {
+		t.Errorf("Expected Xcode to be installed by its resolved ID, calls: %v", r.calls)
+	}
+	if r.called("mas install 409201541") {
+		t.Error("Expected an app that is already present not to be reinstalled")
+	}
+}
+
+const masOutdatedOutput = `497799835 Xcode (15.4 -> 16.0)
+409201541 Pages (14.0 -> 14.1)
+`
+
+func TestUpgradeAppStoreApps(t *testing.T) {
+	tests := []struct {
+		policy  AppStoreUpgrade
+		upgrade string
+	}{
+		{"", ""},
+		{appStoreUpgradeNone, ""},
+		{appStoreUpgradeListed, "mas upgrade 497799835"},
+		{appStoreUpgradeAll, "mas upgrade"},
+	}
+
+	for _, tt := range tests {
+		r := newFakeRunner(map[string]fakeResult{
+			"mas account":  {output: "me@example.com\n"},
+			"mas outdated": {output: masOutdatedOutput},
+		})
+		config := AppStoreConfig{Apps: []AppStoreApp{{Name: "Xcode"}}, Upgrade: tt.policy}
+		results := []appStoreResult{{App: AppStoreApp{ID: "497799835", Name: "Xcode"}, Status: appStoreAlreadyPresent}}
+
+		outdated := upgradeAppStoreApps(r, config, results)
+		if len(outdated) != 2 || outdated[0].Version != "15.4 -> 16.0" {
+			t.Errorf("%q: expected the outdated apps to be returned, got %+v", tt.policy, outdated)
+		}
+
+		var upgrades []string
+		for _, call := range r.calls {
+			if strings.HasPrefix(call, "mas upgrade") {
+				upgrades = append(upgrades, call)
+			}
+		}
+		if tt.upgrade == "" && len(upgrades) != 0 {
+			t.Errorf("%q: expected no upgrades, got %v", tt.policy, upgrades)
+		}
+		if tt.upgrade != "" && (len(upgrades) != 1 || upgrades[0] != tt.upgrade) {
+			t.Errorf("%q: expected %q, got %v", tt.policy, tt.upgrade, upgrades)
+		}
+	}
+}
+
+func TestUpgradeAppStoreAppsNotSignedIn(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"mas account":  {output: "Not signed in\n", err: errors.New("exit status 1")},
+		"mas outdated": {output: masOutdatedOutput},
+	})
+
+	outdated := upgradeAppStoreApps(r, AppStoreConfig{Upgrade: appStoreUpgradeAll}, nil)
+	if len(outdated) != 2 {
+		t.Errorf("Expected 2 outdated apps, got %v", outdated)
+	}
+	if r.called("mas upgrade") {
+		t.Error("Expected no upgrade without an App Store sign-in")
+	}
+}
+
+func TestUpgradeAppStoreAppsWithoutMas(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"mas --version": {err: errors.New("executable file not found")},
+	})
+
+	if outdated := upgradeAppStoreApps(r, AppStoreConfig{Upgrade: appStoreUpgradeAll}, nil); outdated != nil {
+		t.Errorf("Expected nothing to report without mas, got %v", outdated)
+	}
+	if r.called("mas outdated") {
+		t.Error("Expected mas outdated not to run without mas")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Version         int                  `yaml:"version" json:"version" toml:"version"`
+	Vars            map[string]string    `yaml:"vars,omitempty" json:"vars,omitempty" toml:"vars,omitempty"`
+	Host            *HostConfig          `yaml:"host,omitempty" json:"host,omitempty" toml:"host,omitempty" render:"deferred"`
+	SoftwareUpdate  SoftwareUpdateConfig `yaml:"softwareUpdate,omitempty" json:"softwareUpdate,omitempty" toml:"softwareUpdate,omitempty"`
+	Rosetta         *bool                `yaml:"rosetta,omitempty" json:"rosetta,omitempty" toml:"rosetta,omitempty"`
+	Homebrew        HomebrewConfig       `yaml:"homebrew,omitempty" json:"homebrew,omitempty" toml:"homebrew,omitempty"`
+	Casks           []string             `yaml:"casks,omitempty" json:"casks,omitempty" toml:"casks,omitempty"`
+	Formulae        []string             `yaml:"formulae,omitempty" json:"formulae,omitempty" toml:"formulae,omitempty"`
+	AppStore        AppStoreConfig       `yaml:"appStore,omitempty" json:"appStore,omitempty" toml:"appStore,omitempty"`
+	Runtimes        []RuntimeConfig      `yaml:"runtimes,omitempty" json:"runtimes,omitempty" toml:"runtimes,omitempty"`
+	DefaultSettings []string             `yaml:"defaultSettings,omitempty" json:"defaultSettings,omitempty" toml:"defaultSettings,omitempty"`
+	Dock            DockConfig           `yaml:"dock,omitempty" json:"dock,omitempty" toml:"dock,omitempty"`
+	TouchID         *TouchIDConfig       `yaml:"touchID,omitempty" json:"touchID,omitempty" toml:"touchID,omitempty"`
+	Security        *SecurityConfig      `yaml:"security,omitempty" json:"security,omitempty" toml:"security,omitempty"`
+	Git             GitConfig            `yaml:"git,omitempty" json:"git,omitempty" toml:"git,omitempty"`
+	SSH             *SSHConfig           `yaml:"ssh,omitempty" json:"ssh,omitempty" toml:"ssh,omitempty"`
+	DotfilesRepo    string               `yaml:"dotfilesRepo,omitempty" json:"dotfilesRepo,omitempty" toml:"dotfilesRepo,omitempty"`
+	Dotfiles        DotfilesConfig       `yaml:"dotfiles,omitempty" json:"dotfiles,omitempty" toml:"dotfiles,omitempty"`
+}
+
+// DockConfig lists the Dock items to replace, add and remove, in that order.
+type DockConfig struct {
+	Replace []DockReplacement `yaml:"replace,omitempty" json:"replace,omitempty" toml:"replace,omitempty"`
+	Add     []string          `yaml:"add,omitempty" json:"add,omitempty" toml:"add,omitempty"`
+	Remove  []string          `yaml:"remove,omitempty" json:"remove,omitempty" toml:"remove,omitempty"`
+}
+
+// DockReplacement puts App in the Dock in place of the item named Replacing.
+type DockReplacement struct {
+	App       string `yaml:"app" json:"app" toml:"app"`
+	Replacing string `yaml:"replacing" json:"replacing" toml:"replacing"`
+}
+
+// configFlags holds the command line flags that select and verify the config
+// file. Every command that reads a config registers them.
+type configFlags struct {
+	location string
+	sha256   string
+	pubKey   string
+	format   string
+	vars     varFlag
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{vars: varFlag{}}
+	fs.StringVar(&f.location, "config", "deploy_config.yml", "config file path, https:// URL or git+<url>#<path>[@<ref>]")
+	fs.StringVar(&f.sha256, "config-sha256", "", "sha256 digest a remote config must match")
+	fs.StringVar(&f.pubKey, "config-pubkey", "", "base64 ed25519 public key a remote config signature must verify against")
+	fs.StringVar(&f.format, "config-format", "", "config format: yaml, json or toml (default: from the file extension)")
+	fs.Var(f.vars, "var", "template variable as key=value (repeatable)")
+	return f
+}
+
+// resolve returns the local path of the selected config, downloading it first
+// if it is remote.
+func (f *configFlags) resolve() (string, error) {
+	return resolveConfig(nil, f.location, defaultConfigCacheDir(), configPin{
+		SHA256:    f.sha256,
+		PublicKey: f.pubKey,
+	}, nil)
+}
+
+// load resolves, reads and renders the selected config. The returned Config
+// has every template in it expanded; the variables it was rendered with are
+// returned too, for templated dotfiles.
+func (f *configFlags) load() (*Config, map[string]string, error) {
+	path, err := f.resolve()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err := readConfigFormat(path, f.format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vars, err := templateVars(config, f.vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := renderConfig(config, vars); err != nil {
+		return nil, nil, err
+	}
+
+	return config, vars, nil
+}
+
+// readConfig loads the config file, detecting its format from the extension.
+func readConfig(filename string) (*Config, error) {
+	return readConfigFormat(filename, "")
+}
+
+// readConfigFormat loads the config file in the given format ("" to detect
+// it). Unknown keys and values of the wrong shape are rejected so typos such
+// as "cask:" are reported instead of being silently ignored.
+func readConfigFormat(filename, format string) (*Config, error) {
+	format, err := detectConfigFormat(filename, format)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeConfig(data, format)
+}
+
+func decodeConfig(data []byte, format string) (*Config, error) {
+	node, problems, _, err := parseAndMigrateConfig(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{Version: currentConfigVersion}
+	if node == nil {
+		return &config, nil
+	}
+
+	checkNode(node, reflect.TypeOf(config), "config", &problems)
+
+	var errs []error
+	for _, problem := range problems {
+		if problem.Warning {
+			fmt.Fprintf(os.Stderr, "%s\n", problem)
+			continue
+		}
+		errs = append(errs, errors.New(problem.String()))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := node.Decode(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// parseAndMigrateConfig parses a config document and upgrades it to the
+// current version. It returns the migration warnings and errors and the
+// migrations that were applied.
+func parseAndMigrateConfig(data []byte, format string) (*yaml.Node, []configProblem, []string, error) {
+	node, err := parseConfigNode(data, format)
+	if err != nil || node == nil {
+		return nil, nil, nil, err
+	}
+
+	problems, applied, err := migrateConfigNode(node)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return node, problems, applied, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configCommand implements the `gomacdeploy config <subcommand>` group.
+func configCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("Usage: gomacdeploy config convert|migrate [flags] [file]")
+		return 2
+	}
+
+	switch args[0] {
+	case "convert":
+		return convertCommand(args[1:])
+	case "migrate":
+		return migrateCommand(args[1:])
+	}
+
+	fmt.Printf("Unknown config command %q\n", args[0])
+	return 2
+}
+
+// convertCommand translates a config between YAML, JSON and TOML. The target
+// format comes from --to or, failing that, the --output extension. Templates
+// are copied as written rather than rendered.
+func convertCommand(args []string) int {
+	fs := flag.NewFlagSet("config convert", flag.ExitOnError)
+	configFlags := addConfigFlags(fs)
+	to := fs.String("to", "", "target format: yaml, json or toml")
+	output := fs.String("output", "", "file to write (default: standard output)")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		configFlags.location = fs.Arg(0)
+	}
+
+	if *to == "" && *output == "" {
+		fmt.Println("Error: --to or --output is required")
+		return 2
+	}
+	target, err := detectConfigFormat(*output, *to)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 2
+	}
+
+	path, err := configFlags.resolve()
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	config, err := readConfigFormat(path, configFlags.format)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	data, err := encodeConfig(config, target)
+	if err != nil {
+		fmt.Printf("Error converting config: %v\n", err)
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Printf("Error writing %s: %v\n", *output, err)
+		return 1
+	}
+
+	fmt.Printf("Wrote %s\n", *output)
+	return 0
+}
+
+// migrateCommand upgrades a config to the newest version. The result is
+// printed unless --write is given, in which case the file is rewritten in
+// place. YAML files keep their comments; JSON and TOML files are rewritten
+// from the decoded config.
+func migrateCommand(args []string) int {
+	fs := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	configFlags := addConfigFlags(fs)
+	write := fs.Bool("write", false, "rewrite the config file instead of printing the result")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		configFlags.location = fs.Arg(0)
+	}
+
+	if *write && isRemoteConfig(configFlags.location, nil) {
+		fmt.Println("Error: --write cannot be used with a remote config")
+		return 2
+	}
+
+	path, err := configFlags.resolve()
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	format, err := detectConfigFormat(path, configFlags.format)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	node, problems, applied, err := parseAndMigrateConfig(data, format)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+	if node != nil {
+		checkNode(node, reflect.TypeOf(Config{}), "config", &problems)
+	}
+
+	failed := false
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem.in(configFlags.location))
+		failed = failed || !problem.Warning
+	}
+	if failed {
+		fmt.Println("Error: fix the problems above before migrating")
+		return 1
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("%s is already at version %d.\n", configFlags.location, currentConfigVersion)
+		return 0
+	}
+
+	var out []byte
+	if format == formatYAML {
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(node); err == nil {
+			err = encoder.Close()
+		}
+		out = buf.Bytes()
+	} else {
+		var config Config
+		if err = node.Decode(&config); err == nil {
+			out, err = encodeConfig(&config, format)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error migrating config: %v\n", err)
+		return 1
+	}
+
+	if !*write {
+		os.Stdout.Write(out)
+		return 0
+	}
+
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		fmt.Printf("Error writing %s: %v\n", path, err)
+		return 1
+	}
+
+	fmt.Printf("Migrated %s to version %d:\n", path, currentConfigVersion)
+	for _, migration := range applied {
+		fmt.Printf("  %s\n", migration)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTempConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigRejectsUnknownKeys(t *testing.T) {
+	path := writeTempConfig(t, "deploy.yml", "cask:\n  - google-chrome\n")
+
+	_, err := readConfig(path)
+	if err == nil {
+		t.Fatal("Expected unknown key error, got nil")
+	}
+	if !strings.Contains(err.Error(), "cask") {
+		t.Errorf("Expected error to mention the unknown key, got %v", err)
+	}
+}
+
+func TestReadConfigEmptyFile(t *testing.T) {
+	config, err := readConfig(writeTempConfig(t, "deploy.yml", ""))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(config.Casks) != 0 {
+		t.Errorf("Expected empty config, got %v", config)
+	}
+}
+
+func TestReadDeployConfig(t *testing.T) {
+	if _, err := readConfig("deploy_config.yml"); err != nil {
+		t.Fatalf("Expected shipped config to be valid, got %v", err)
+	}
+}
+
+// TestSchemaMatchesConfig keeps the published JSON Schema in step with the
+// keys readConfig accepts.
+// schemaNode is the part of a JSON Schema TestSchemaMatchesConfig compares.
+type schemaNode struct {
+	Ref        string                 `json:"$ref"`
+	Properties map[string]*schemaNode `json:"properties"`
+	Items      *schemaNode            `json:"items"`
+	OneOf      []*schemaNode          `json:"oneOf"`
+}
+
+func TestSchemaMatchesConfig(t *testing.T) {
+	data, err := os.ReadFile("deploy_config.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		schemaNode
+		Definitions map[string]*schemaNode `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Expected valid JSON Schema, got %v", err)
+	}
+
+	// compare walks a config type alongside its schema, so nested sections
+	// are covered as well as the top-level keys.
+	var compare func(typ reflect.Type, node *schemaNode, path string)
+	compare = func(typ reflect.Type, node *schemaNode, path string) {
+		if node == nil {
+			return
+		}
+		if name, ok := strings.CutPrefix(node.Ref, "#/definitions/"); ok {
+			node = schema.Definitions[name]
+		}
+		switch typ.Kind() {
+		case reflect.Pointer:
+			compare(typ.Elem(), node, path)
+			return
+		case reflect.Slice:
+			compare(typ.Elem(), node.Items, path+"[]")
+			return
+		case reflect.Struct:
+		default:
+			return
+		}
+
+		// A type with several accepted shapes is compared against the one
+		// that is an object.
+		for _, alternative := range node.OneOf {
+			if alternative.Properties != nil {
+				node = alternative
+			}
+		}
+		fields := yamlFields(typ)
+		for name, field := range fields {
+			property, ok := node.Properties[name]
+			if !ok {
+				t.Errorf("Config key %q is missing from the schema", strings.TrimPrefix(path+"."+name, "."))
+				continue
+			}
+			compare(field.Type, property, strings.TrimPrefix(path+"."+name, "."))
+		}
+		for name := range node.Properties {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("Schema property %q is not a Config key", strings.TrimPrefix(path+"."+name, "."))
+			}
+		}
+	}
+	compare(reflect.TypeOf(Config{}), &schema.schemaNode, "")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default locations for the dotfiles step.
+const (
+	defaultDotfilesDir    = "~/.dotfiles"
+	defaultDotfilesBackup = "~/.dotfiles-backup"
+)
+
+// DotfilesConfig controls how the repository named by dotfilesRepo is checked
+// out and linked. Each top-level directory of the repository is a package
+// whose files are linked into the home directory, the way GNU Stow does it.
+// Ignore lists extra regular expressions for paths to skip.
+type DotfilesConfig struct {
+	Dir      string   `yaml:"dir,omitempty" json:"dir,omitempty" toml:"dir,omitempty"`
+	Branch   string   `yaml:"branch,omitempty" json:"branch,omitempty" toml:"branch,omitempty"`
+	Packages []string `yaml:"packages,omitempty" json:"packages,omitempty" toml:"packages,omitempty"`
+	Backup   string   `yaml:"backup,omitempty" json:"backup,omitempty" toml:"backup,omitempty"`
+	Ignore   []string `yaml:"ignore,omitempty" json:"ignore,omitempty" toml:"ignore,omitempty"`
+}
+
+func (d DotfilesConfig) dir() string {
+	if d.Dir == "" {
+		return expandHome(defaultDotfilesDir)
+	}
+	return expandHome(d.Dir)
+}
+
+func (d DotfilesConfig) backupDir() string {
+	if d.Backup == "" {
+		return expandHome(defaultDotfilesBackup)
+	}
+	return expandHome(d.Backup)
+}
+
+// syncDotfilesRepo clones repo into dir, or fast-forwards an existing clone.
+func syncDotfilesRepo(r Runner, repo, dir, branch string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		fmt.Printf("Updating dotfiles in %s...\n", dir)
+		return r.Run("git", "-C", dir, "pull", "--ff-only")
+	}
+
+	fmt.Printf("Cloning %s into %s...\n", repo, dir)
+	args := []string{"clone"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	return r.Run("git", append(args, repo, dir)...)
+}
+
+// dotfilesPackages returns the packages to link: the selected ones, or every
+// top-level directory of the repository that is not hidden.
+func dotfilesPackages(dir string, selected []string) ([]string, error) {
+	if len(selected) > 0 {
+		for _, pkg := range selected {
+			info, err := os.Stat(filepath.Join(dir, pkg))
+			if err != nil || !info.IsDir() {
+				return nil, fmt.Errorf("package %q not found in %s", pkg, dir)
+			}
+		}
+		return selected, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var packages []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			packages = append(packages, entry.Name())
+		}
+	}
+	sort.Strings(packages)
+	return packages, nil
+}
+
+// installDotfiles checks out the dotfiles repository and links the selected
+// packages into home with the same linker as `gomacdeploy link`, then renders
+// their .tmpl files with vars. Files it replaces are backed up under a
+// timestamped directory, so nothing is lost. It returns the rendered files
+// that were kept because they were edited locally.
+func installDotfiles(r Runner, repo string, config DotfilesConfig, home string, vars map[string]string) ([]string, error) {
+	clearScreen()
+	if repo == "" {
+		return nil, nil
+	}
+
+	dir := config.dir()
+	if err := syncDotfilesRepo(r, repo, dir, config.Branch); err != nil {
+		return nil, fmt.Errorf("updating %s: %v", dir, err)
+	}
+
+	packages, err := dotfilesPackages(dir, config.Packages)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Linking %s...\n", strings.Join(packages, ", "))
+	backup := filepath.Join(config.backupDir(), time.Now().Format("20060102-150405"))
+	opts := stowOptions{
+		dir:    dir,
+		target: home,
+		ignore: config.Ignore,
+		backup: backup,
+	}
+	actions, conflicts, err := stowPackages(opts, packages, stowOpLink, false)
+	if len(conflicts) > 0 {
+		lines := make([]string, len(conflicts))
+		for i, conflict := range conflicts {
+			lines[i] = conflict.String()
+		}
+		return nil, fmt.Errorf("conflicts, nothing was linked:\n  %s", strings.Join(lines, "\n  "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("linking dotfiles: %v", err)
+	}
+	for _, action := range actions {
+		fmt.Printf("  %s\n", action)
+	}
+
+	return renderDotfileTemplates(dir, packages, home, backup, config.Ignore, vars)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// renderedStatePath is where the hashes of rendered dotfiles are kept,
+// relative to the home directory.
+var renderedStatePath = filepath.Join(".local", "state", "gomacdeploy", "rendered.json")
+
+// renderedState maps each rendered file to the sha256 of what was last
+// written to it.
+type renderedState map[string]string
+
+func readRenderedState(path string) (renderedState, error) {
+	state := renderedState{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return state, nil
+}
+
+func (s renderedState) write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func renderedHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// dotfileTemplates returns the templates in the package, keyed by the path
+// of the rendered file relative to the package root.
+func dotfileTemplates(pkgDir string, ignore []string) (map[string]string, error) {
+	ig, err := newIgnorer(pkgDir, ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]string)
+	err = filepath.WalkDir(pkgDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(pkgDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if ig.ignored(filepath.ToSlash(rel)) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && strings.HasSuffix(rel, templateSuffix) {
+			templates[strings.TrimSuffix(rel, templateSuffix)] = path
+		}
+		return nil
+	})
+	return templates, err
+}
+
+// renderDotfileTemplates renders every .tmpl file of the packages with vars
+// and writes the result to the same path in home without the suffix. A
+// rendered file is only replaced if it still holds what was last written to
+// it; files edited since are left alone and returned. A file that was there
+// before the first render is moved into backup, as the linker does.
+func renderDotfileTemplates(dir string, packages []string, home, backup string, ignore []string, vars map[string]string) ([]string, error) {
+	statePath := filepath.Join(home, renderedStatePath)
+	state, err := readRenderedState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var edited []string
+	for _, pkg := range packages {
+		templates, err := dotfileTemplates(filepath.Join(dir, pkg), ignore)
+		if err != nil {
+			return edited, err
+		}
+
+		rels := make([]string, 0, len(templates))
+		for rel := range templates {
+			rels = append(rels, rel)
+		}
+		sort.Strings(rels)
+
+		for _, rel := range rels {
+			source := templates[rel]
+			dest := filepath.Join(home, rel)
+			if err := checkNotFolded(dir, home, rel); err != nil {
+				return edited, fmt.Errorf("rendering %s: %v", source, err)
+			}
+			kept, err := renderDotfile(source, dest, filepath.Join(backup, rel), vars, state)
+			if err != nil {
+				return edited, fmt.Errorf("rendering %s: %v", source, err)
+			}
+			if kept {
+				edited = append(edited, dest)
+			}
+		}
+	}
+
+	return edited, state.write(statePath)
+}
+
+// checkNotFolded returns an error if a directory between home and rel is a
+// link into the dotfiles repository dir, as the linker leaves a folded
+// directory. Rendering through it would write into the repository.
+func checkNotFolded(dir, home, rel string) error {
+	repo, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	parent := home
+	for _, name := range strings.Split(filepath.Dir(rel), string(filepath.Separator)) {
+		if name == "." {
+			break
+		}
+		parent = filepath.Join(parent, name)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(parent)
+		if err != nil {
+			return err
+		}
+		if inside, err := filepath.Rel(repo, resolved); err == nil && inside != ".." && !strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s is a folded link into %s; unlink and link the package again to unfold it", parent, dir)
+		}
+	}
+	return nil
+}
+
+// renderDotfile renders one template to dest and records its hash. It reports
+// whether dest was kept because it was edited locally.
+func renderDotfile(source, dest, backup string, vars map[string]string, state renderedState) (bool, error) {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return false, err
+	}
+	rendered, err := renderString(string(data), vars)
+	if err != nil {
+		return false, err
+	}
+	hash := renderedHash([]byte(rendered))
+
+	if destInfo, err := os.Lstat(dest); err == nil {
+		if destInfo.Mode()&os.ModeSymlink != 0 || destInfo.IsDir() {
+			fmt.Printf("  kept %s: it is not a regular file\n", dest)
+			return true, nil
+		}
+		current, err := os.ReadFile(dest)
+		if err != nil {
+			return false, err
+		}
+		currentHash := renderedHash(current)
+		switch {
+		case currentHash == hash:
+			state[dest] = hash
+			return false, nil
+		case state[dest] == "":
+			if err := os.MkdirAll(filepath.Dir(backup), 0755); err != nil {
+				return false, err
+			}
+			if err := os.Rename(dest, backup); err != nil {
+				return false, err
+			}
+			fmt.Printf("  backup %s to %s\n", dest, backup)
+		case currentHash != state[dest]:
+			fmt.Printf("  kept %s: it was edited since it was rendered\n", dest)
+			return true, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(dest, []byte(rendered), info.Mode().Perm()); err != nil {
+		return false, err
+	}
+	state[dest] = hash
+	fmt.Printf("  rendered %s\n", dest)
+	return false, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderDotfileTemplates(t *testing.T) {
+	dir, home := newStowDirs(t, map[string]string{
+		"git/.gitconfig.tmpl":           "[user]\n\temail = {{ .workEmail }}\n",
+		"zsh/.config/zsh/brew.zsh.tmpl": "eval \"$({{ .brewPrefix }}/bin/brew shellenv)\"\n",
+		"zsh/.config/zsh/aliases.zsh":   "alias ll='ls -l'\n",
+	})
+	backup := filepath.Join(t.TempDir(), "backup")
+	vars := map[string]string{"workEmail": "me@work.example.com", "brewPrefix": "/opt/homebrew"}
+	packages := []string{"git", "zsh"}
+
+	// The linker must not fold a directory holding templates, or the rendered
+	// file would land inside the package.
+	opts := stowOptions{dir: dir, target: home}
+	if _, conflicts, err := stowPackages(opts, packages, stowOpLink, false); err != nil || len(conflicts) > 0 {
+		t.Fatalf("Expected no error, got %v, %v", conflicts, err)
+	}
+	if info, err := os.Lstat(filepath.Join(home, ".config", "zsh")); err != nil || !info.IsDir() {
+		t.Fatalf("Expected a real directory for templates, got %v, %v", info, err)
+	}
+	readLink(t, filepath.Join(home, ".config", "zsh", "aliases.zsh"))
+	if _, err := os.Lstat(filepath.Join(home, ".gitconfig.tmpl")); !os.IsNotExist(err) {
+		t.Errorf("Expected templates not to be linked, got %v", err)
+	}
+
+	edited, err := renderDotfileTemplates(dir, packages, home, backup, nil, vars)
+	if err != nil || len(edited) != 0 {
+		t.Fatalf("Expected no error, got %v, %v", edited, err)
+	}
+	gitconfig := filepath.Join(home, ".gitconfig")
+	if data := string(mustRead(t, gitconfig)); data != "[user]\n\temail = me@work.example.com\n" {
+		t.Errorf("Unexpected rendered .gitconfig %q", data)
+	}
+	if data := string(mustRead(t, filepath.Join(home, ".config", "zsh", "brew.zsh"))); !strings.Contains(data, "/opt/homebrew/bin/brew") {
+		t.Errorf("Unexpected rendered brew.zsh %q", data)
+	}
+
+	// An untouched rendered file follows changes to the variables.
+	vars["workEmail"] = "me@new.example.com"
+	if edited, err := renderDotfileTemplates(dir, packages, home, backup, nil, vars); err != nil || len(edited) != 0 {
+		t.Fatalf("Expected no error, got %v, %v", edited, err)
+	}
+	if data := string(mustRead(t, gitconfig)); !strings.Contains(data, "me@new.example.com") {
+		t.Errorf("Expected the file to be re-rendered, got %q", data)
+	}
+
+	// A locally edited one is kept and reported.
+	os.WriteFile(gitconfig, []byte("[user]\n\temail = mine@example.com\n"), 0644)
+	vars["workEmail"] = "me@third.example.com"
+	edited, err = renderDotfileTemplates(dir, packages, home, backup, nil, vars)
+	if err != nil || len(edited) != 1 || edited[0] != gitconfig {
+		t.Fatalf("Expected the edited file to be reported, got %v, %v", edited, err)
+	}
+	if data := string(mustRead(t, gitconfig)); !strings.Contains(data, "mine@example.com") {
+		t.Errorf("Expected the local edit to be kept, got %q", data)
+	}
+}
+
+func TestRenderDotfileTemplatesRefusesFoldedDirectories(t *testing.T) {
+	dir, home := newStowDirs(t, map[string]string{
+		"zsh/.config/zsh/aliases.zsh": "alias ll='ls -l'\n",
+	})
+	opts := stowOptions{dir: dir, target: home}
+	if _, conflicts, err := stowPackages(opts, []string{"zsh"}, stowOpLink, false); err != nil || len(conflicts) > 0 {
+		t.Fatalf("Expected no error, got %v, %v", conflicts, err)
+	}
+	readLink(t, filepath.Join(home, ".config"))
+
+	// A template added after the package was linked sits under the folded
+	// link.
+	template := filepath.Join(dir, "zsh", ".config", "zsh", "brew.zsh.tmpl")
+	if err := os.WriteFile(template, []byte("eval \"$({{ .brewPrefix }}/bin/brew shellenv)\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{"brewPrefix": "/opt/homebrew"}
+	_, err := renderDotfileTemplates(dir, []string{"zsh"}, home, filepath.Join(t.TempDir(), "backup"), nil, vars)
+	if err == nil || !strings.Contains(err.Error(), "folded") {
+		t.Fatalf("Expected a folded directory error, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "zsh", ".config", "zsh", "brew.zsh")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written into the repository, got %v", err)
+	}
+}
+
+func TestRenderDotfileTemplatesBacksUpExistingFiles(t *testing.T) {
+	dir, home := newStowDirs(t, map[string]string{"git/.gitconfig.tmpl": "[user]\n\tname = {{ .user }}\n"})
+	os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("old\n"), 0644)
+	backup := filepath.Join(t.TempDir(), "backup")
+
+	edited, err := renderDotfileTemplates(dir, []string{"git"}, home, backup, nil, map[string]string{"user": "me"})
+	if err != nil || len(edited) != 0 {
+		t.Fatalf("Expected no error, got %v, %v", edited, err)
+	}
+	if data := string(mustRead(t, filepath.Join(backup, ".gitconfig"))); data != "old\n" {
+		t.Errorf("Expected the existing file in the backup, got %q", data)
+	}
+	if data := string(mustRead(t, filepath.Join(home, ".gitconfig"))); data != "[user]\n\tname = me\n" {
+		t.Errorf("Unexpected rendered .gitconfig %q", data)
+	}
+}
+
+func TestRenderDotfileTemplatesUndefinedVariable(t *testing.T) {
+	dir, home := newStowDirs(t, map[string]string{"git/.gitconfig.tmpl": "{{ .missing }}\n"})
+
+	_, err := renderDotfileTemplates(dir, []string{"git"}, home, t.TempDir(), nil, map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), ".gitconfig.tmpl") {
+		t.Errorf("Expected an error naming the template, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".gitconfig")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLink(t *testing.T, path string) string {
+	t.Helper()
+	link, err := os.Readlink(path)
+	if err != nil {
+		t.Fatalf("Expected %s to be a link, got %v", path, err)
+	}
+	return link
+}
+
+func TestInstallDotfiles(t *testing.T) {
+	repo := newBareConfigRepo(t, map[string]string{
+		"zsh/.zshrc":                 "export EDITOR=nvim\n",
+		"git/.gitconfig":             "[core]\n",
+		"nvim/.config/nvim/init.lua": "-- nvim\n",
+		".github/workflows/ci.yml":   "on: push\n",
+	})
+
+	home := t.TempDir()
+	dir := filepath.Join(home, ".dotfiles")
+	backup := filepath.Join(home, "backup")
+	if err := os.WriteFile(filepath.Join(home, ".zshrc"), []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DotfilesConfig{Dir: dir, Branch: "main", Packages: []string{"zsh", "nvim"}, Backup: backup}
+	if _, err := installDotfiles(execRunner{}, repo, config, home, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if link := readLink(t, filepath.Join(home, ".zshrc")); link != ".dotfiles/zsh/.zshrc" {
+		t.Errorf("Expected a relative link into the package, got %s", link)
+	}
+	if data, err := os.ReadFile(filepath.Join(home, ".config", "nvim", "init.lua")); err != nil || string(data) != "-- nvim\n" {
+		t.Errorf("Expected the nested file to be linked, got %q, %v", data, err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".gitconfig")); !os.IsNotExist(err) {
+		t.Errorf("Expected unselected packages not to be linked, got %v", err)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(backup, "*", ".zshrc"))
+	if len(backups) != 1 {
+		t.Fatalf("Expected the existing .zshrc to be backed up, got %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); string(data) != "old\n" {
+		t.Errorf("Expected the backup to keep the old content, got %q", data)
+	}
+
+	// Push a change and run again: the clone is updated and nothing else moves.
+	work := filepath.Join(t.TempDir(), "work")
+	runGit(t, filepath.Dir(work), "clone", "-q", "--branch", "main", repo, work)
+	os.WriteFile(filepath.Join(work, "zsh", ".zshrc"), []byte("export EDITOR=vim\n"), 0644)
+	runGit(t, work, "commit", "-q", "-am", "vim")
+	runGit(t, work, "push", "-q", "origin", "main")
+
+	if _, err := installDotfiles(execRunner{}, repo, config, home, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(home, ".zshrc")); string(data) != "export EDITOR=vim\n" {
+		t.Errorf("Expected the pulled change through the link, got %q", data)
+	}
+	if backups, _ := filepath.Glob(filepath.Join(backup, "*", ".zshrc")); len(backups) != 1 {
+		t.Errorf("Expected no new backups on the second run, got %v", backups)
+	}
+}
+
+// captureStdout returns what fn prints.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestInstallDotfilesConflictLinksNothing(t *testing.T) {
+	repo := newBareConfigRepo(t, map[string]string{
+		"zsh/.zshrc":                 "export EDITOR=nvim\n",
+		"nvim/.config/nvim/init.lua": "-- nvim\n",
+	})
+
+	home := t.TempDir()
+	// A directory in the way of a package file cannot be backed up.
+	if err := os.Mkdir(filepath.Join(home, ".zshrc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DotfilesConfig{Dir: filepath.Join(home, ".dotfiles"), Branch: "main", Packages: []string{"zsh", "nvim"}, Backup: filepath.Join(home, "backup")}
+	var err error
+	output := captureStdout(t, func() {
+		_, err = installDotfiles(execRunner{}, repo, config, home, nil)
+	})
+	if err == nil || !strings.Contains(err.Error(), "nothing was linked") {
+		t.Fatalf("Expected a conflict error, got %v", err)
+	}
+	if strings.Contains(output, "  link ") {
+		t.Errorf("Expected no link actions to be printed, got:\n%s", output)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".config")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be linked, got %v", err)
+	}
+}
+
+func TestDotfilesPackages(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"zsh", "git", ".github"} {
+		os.Mkdir(filepath.Join(dir, name), 0755)
+	}
+	os.WriteFile(filepath.Join(dir, "README.md"), nil, 0644)
+
+	packages, err := dotfilesPackages(dir, nil)
+	if err != nil || strings.Join(packages, ",") != "git,zsh" {
+		t.Errorf("Expected the visible directories, got %v, %v", packages, err)
+	}
+
+	if _, err := dotfilesPackages(dir, []string{"zsh", "tmux"}); err == nil || !strings.Contains(err.Error(), "tmux") {
+		t.Errorf("Expected a missing package error, got %v", err)
+	}
+}
+
+func TestInstallDotfilesWithoutRepo(t *testing.T) {
+	r := newFakeRunner(nil)
+	if _, err := installDotfiles(r, "", DotfilesConfig{}, t.TempDir(), nil); err != nil || len(r.calls) != 0 {
+		t.Errorf("Expected nothing to happen without dotfilesRepo, got %v, %v", err, r.calls)
+	}
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported config file formats.
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+	formatTOML = "toml"
+)
+
+// detectConfigFormat returns the format of filename. An explicit format (from
+// --config-format) wins over the file extension; files with an unknown
+// extension are read as YAML.
+func detectConfigFormat(filename, format string) (string, error) {
+	if format != "" {
+		switch strings.ToLower(format) {
+		case "yaml", "yml":
+			return formatYAML, nil
+		case "json":
+			return formatJSON, nil
+		case "toml":
+			return formatTOML, nil
+		}
+		return "", fmt.Errorf("unsupported config format %q (use yaml, json or toml)", format)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return formatJSON, nil
+	case ".toml":
+		return formatTOML, nil
+	}
+	return formatYAML, nil
+}
+
+// parseConfigNode parses a config document into a YAML node tree. Every
+// format goes through the same tree so the schema checks and decoding rules
+// are identical for YAML, JSON and TOML. YAML and JSON nodes carry their
+// line and column; TOML nodes get theirs from scanTOMLPositions. A nil node
+// means an empty document.
+func parseConfigNode(data []byte, format string) (*yaml.Node, error) {
+	switch format {
+	case formatJSON:
+		return parseJSONNode(data)
+
+	case formatTOML:
+		var doc map[string]interface{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, err
+		}
+		if len(doc) == 0 {
+			return nil, nil
+		}
+		var node yaml.Node
+		if err := node.Encode(doc); err != nil {
+			return nil, err
+		}
+		clearPositions(&node)
+		node.Line, node.Column = 1, 1
+		applyTOMLPositions(&node, "", scanTOMLPositions(data))
+		return &node, nil
+
+	default:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			return nil, nil
+		}
+		// Keep the comments above the first key (such as the schema
+		// modeline) with the document when it is written back out.
+		root := doc.Content[0]
+		if doc.HeadComment != "" {
+			root.HeadComment = strings.TrimSpace(doc.HeadComment + "\n\n" + root.HeadComment)
+		}
+		return root, nil
+	}
+}
+
+func clearPositions(node *yaml.Node) {
+	node.Line, node.Column = 0, 0
+	for _, child := range node.Content {
+		clearPositions(child)
+	}
+}
+
+// tomlPosition is where a TOML key and its value start.
+type tomlPosition struct {
+	keyLine, keyColumn int
+	line, column       int
+}
+
+// tomlScanner records where each key and value of a TOML document starts.
+// The toml package keeps these to itself, so the document is scanned again
+// after it has decoded, and the scanner can assume it is well formed. Paths
+// are the keys joined with "\x00", with "#<i>" for the i-th array element.
+type tomlScanner struct {
+	data         []byte
+	offset       int
+	line, column int
+	positions    map[string]*tomlPosition
+	arrayTables  map[string]int
+}
+
+func scanTOMLPositions(data []byte) map[string]*tomlPosition {
+	s := &tomlScanner{data: data, line: 1, column: 1, positions: map[string]*tomlPosition{}, arrayTables: map[string]int{}}
+	var table []string
+	for {
+		s.skipBlank(true)
+		if s.done() {
+			return s.positions
+		}
+		line, column := s.line, s.column
+		switch {
+		case s.peek(0) == '[' && s.peek(1) == '[':
+			s.advance(2)
+			keys := s.resolve(s.keyPath())
+			s.skipTo('\n')
+			name := tomlPath(keys)
+			index := s.arrayTables[name]
+			s.arrayTables[name] = index + 1
+			if index == 0 {
+				s.key(keys, line, column)
+				s.value(keys, line, column)
+			}
+			table = append(keys, fmt.Sprintf("#%d", index))
+			s.value(table, line, column)
+		case s.peek(0) == '[':
+			s.advance(1)
+			table = s.resolve(s.keyPath())
+			s.key(table, line, column)
+			s.value(table, line, column)
+			s.skipTo('\n')
+		default:
+			s.keyValue(table)
+		}
+	}
+}
+
+func tomlPath(keys []string) string {
+	return strings.Join(keys, "\x00")
+}
+
+// resolve inserts the index of the current element after each array of
+// tables in keys, so [fruit.variety] after [[fruit]] refers to the last
+// fruit.
+func (s *tomlScanner) resolve(keys []string) []string {
+	var resolved []string
+	for i, key := range keys {
+		resolved = append(resolved, key)
+		if n, ok := s.arrayTables[tomlPath(resolved)]; ok && i < len(keys)-1 {
+			resolved = append(resolved, fmt.Sprintf("#%d", n-1))
+		}
+	}
+	return resolved
+}
+
+// key records where the key at path starts, and where its value does, if
+// that is not known yet.
+func (s *tomlScanner) key(path []string, line, column int) {
+	name := tomlPath(path)
+	if p, ok := s.positions[name]; ok {
+		if p.keyLine == 0 {
+			p.keyLine, p.keyColumn = line, column
+		}
+		return
+	}
+	s.positions[name] = &tomlPosition{keyLine: line, keyColumn: column, line: line, column: column}
+}
+
+func (s *tomlScanner) value(path []string, line, column int) {
+	name := tomlPath(path)
+	if p, ok := s.positions[name]; ok {
+		p.line, p.column = line, column
+		return
+	}
+	s.positions[name] = &tomlPosition{line: line, column: column}
+}
+
+// keyValue scans key = value, with the key relative to table.
+func (s *tomlScanner) keyValue(table []string) {
+	line, column := s.line, s.column
+	keys := s.keyPath()
+	for i := range keys {
+		s.key(append(append([]string{}, table...), keys[:i+1]...), line, column)
+	}
+	s.skipBlank(false)
+	s.advance(1) // =
+	s.skipBlank(false)
+	s.scanValue(append(append([]string{}, table...), keys...))
+}
+
+// keyPath scans a dotted key.
+func (s *tomlScanner) keyPath() []string {
+	var keys []string
+	for {
+		s.skipBlank(false)
+		switch c := s.peek(0); c {
+		case '"', '\'':
+			start := s.offset
+			s.skipString()
+			var key string
+			if c == '"' {
+				json.Unmarshal(s.data[start:s.offset], &key)
+			} else {
+				key = string(s.data[start+1 : s.offset-1])
+			}
+			keys = append(keys, key)
+		default:
+			start := s.offset
+			for isTOMLBareKey(s.peek(0)) {
+				s.advance(1)
+			}
+			keys = append(keys, string(s.data[start:s.offset]))
+		}
+		s.skipBlank(false)
+		if s.peek(0) != '.' {
+			return keys
+		}
+		s.advance(1)
+	}
+}
+
+func isTOMLBareKey(c byte) bool {
+	return c == '_' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func (s *tomlScanner) scanValue(path []string) {
+	s.value(path, s.line, s.column)
+	switch s.peek(0) {
+	case '"', '\'':
+		s.skipString()
+	case '[':
+		s.advance(1)
+		for i := 0; ; i++ {
+			s.skipBlank(true)
+			if s.done() || s.peek(0) == ']' {
+				break
+			}
+			s.scanValue(append(append([]string{}, path...), fmt.Sprintf("#%d", i)))
+			s.skipBlank(true)
+			if s.peek(0) == ',' {
+				s.advance(1)
+			}
+		}
+		s.advance(1)
+	case '{':
+		s.advance(1)
+		for {
+			s.skipBlank(false)
+			if s.done() || s.peek(0) == '}' {
+				break
+			}
+			s.keyValue(path)
+			s.skipBlank(false)
+			if s.peek(0) == ',' {
+				s.advance(1)
+			}
+		}
+		s.advance(1)
+	default:
+		for !s.done() && !strings.ContainsRune(",]}\n#", rune(s.peek(0))) {
+			s.advance(1)
+		}
+	}
+}
+
+// skipString skips a basic, literal or multi-line string.
+func (s *tomlScanner) skipString() {
+	quote := s.peek(0)
+	if s.peek(1) == quote && s.peek(2) == quote {
+		s.advance(3)
+		for !s.done() && !(s.peek(0) == quote && s.peek(1) == quote && s.peek(2) == quote) {
+			if quote == '"' && s.peek(0) == '\\' {
+				s.advance(1)
+			}
+			s.advance(1)
+		}
+		s.advance(3)
+		// A multi-line string may end with up to two more quotes.
+		for s.peek(0) == quote {
+			s.advance(1)
+		}
+		return
+	}
+	s.advance(1)
+	for !s.done() && s.peek(0) != quote {
+		if quote == '"' && s.peek(0) == '\\' {
+			s.advance(1)
+		}
+		s.advance(1)
+	}
+	s.advance(1)
+}
+
+// skipBlank skips spaces, tabs and comments, and newlines too if newlines is
+// set.
+func (s *tomlScanner) skipBlank(newlines bool) {
+	for !s.done() {
+		switch c := s.peek(0); {
+		case c == ' ' || c == '\t' || c == '\r':
+			s.advance(1)
+		case c == '\n' && newlines:
+			s.advance(1)
+		case c == '#':
+			s.skipTo('\n')
+		default:
+			return
+		}
+	}
+}
+
+// skipTo skips to the next c without consuming it.
+func (s *tomlScanner) skipTo(c byte) {
+	for !s.done() && s.peek(0) != c {
+		s.advance(1)
+	}
+}
+
+func (s *tomlScanner) done() bool {
+	return s.offset >= len(s.data)
+}
+
+func (s *tomlScanner) peek(n int) byte {
+	if s.offset+n >= len(s.data) {
+		return 0
+	}
+	return s.data[s.offset+n]
+}
+
+func (s *tomlScanner) advance(n int) {
+	for ; n > 0 && !s.done(); n-- {
+		if s.data[s.offset] == '\n' {
+			s.line, s.column = s.line+1, 1
+		} else if s.data[s.offset]&0xC0 != 0x80 {
+			s.column++
+		}
+		s.offset++
+	}
+}
+
+// applyTOMLPositions copies the scanned positions onto the nodes built from
+// the decoded document.
+func applyTOMLPositions(node *yaml.Node, path string, positions map[string]*tomlPosition) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "\x00" + key
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child := join(key.Value)
+			if p, ok := positions[child]; ok {
+				key.Line, key.Column = p.keyLine, p.keyColumn
+				value.Line, value.Column = p.line, p.column
+			}
+			applyTOMLPositions(value, child, positions)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			child := join(fmt.Sprintf("#%d", i))
+			if p, ok := positions[child]; ok {
+				item.Line, item.Column = p.line, p.column
+			}
+			applyTOMLPositions(item, child, positions)
+		}
+	}
+}
+
+// jsonParser builds a YAML node tree from a JSON document, recording where
+// each value starts so problems can be reported with a line and column.
+type jsonParser struct {
+	data       []byte
+	dec        *json.Decoder
+	lineStarts []int
+}
+
+func parseJSONNode(data []byte) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	p := &jsonParser{data: data, dec: json.NewDecoder(bytes.NewReader(data)), lineStarts: []int{0}}
+	p.dec.UseNumber()
+	for i, b := range data {
+		if b == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+
+	node, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.dec.Token(); !errors.Is(err, io.EOF) {
+		line, col := p.position(p.tokenStart())
+		return nil, fmt.Errorf("line %d: column %d: unexpected data after the top-level value", line, col)
+	}
+	return node, nil
+}
+
+// tokenStart returns the offset of the next token, skipping the whitespace
+// and separators the decoder consumes implicitly.
+func (p *jsonParser) tokenStart() int {
+	offset := int(p.dec.InputOffset())
+	for offset < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+func (p *jsonParser) position(offset int) (int, int) {
+	line := sort.Search(len(p.lineStarts), func(i int) bool { return p.lineStarts[i] > offset })
+	return line, offset - p.lineStarts[line-1] + 1
+}
+
+func (p *jsonParser) error(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := p.position(int(syntaxErr.Offset))
+		return fmt.Errorf("line %d: column %d: %v", line, col, err)
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("unexpected end of JSON input")
+	}
+	return err
+}
+
+func (p *jsonParser) value() (*yaml.Node, error) {
+	line, col := p.position(p.tokenStart())
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, p.error(err)
+	}
+
+	node := &yaml.Node{Line: line, Column: col}
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '{' {
+			node.Kind, node.Tag = yaml.MappingNode, "!!map"
+			for p.dec.More() {
+				key, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				value, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, key, value)
+			}
+		} else {
+			node.Kind, node.Tag = yaml.SequenceNode, "!!seq"
+			for p.dec.More() {
+				item, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, item)
+			}
+		}
+		if _, err := p.dec.Token(); err != nil {
+			return nil, p.error(err)
+		}
+
+	case string:
+		node.Kind, node.Tag, node.Value = yaml.ScalarNode, "!!str", v
+
+	case json.Number:
+		node.Kind, node.Tag, node.Value = yaml.ScalarNode, "!!int", v.String()
+		if strings.ContainsAny(v.String(), ".eE") {
+			node.Tag = "!!float"
+		}
+
+	case bool:
+		node.Kind, node.Tag, node.Value = yaml.ScalarNode, "!!bool", fmt.Sprint(v)
+
+	case nil:
+		node.Kind, node.Tag, node.Value = yaml.ScalarNode, "!!null", "null"
+	}
+
+	return node, nil
+}
+
+// encodeConfig serializes config in the given format.
+func encodeConfig(config *Config, format string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(omitEmptySections(reflect.ValueOf(config))); err != nil {
+			return nil, err
+		}
+
+	case formatTOML:
+		if err := toml.NewEncoder(&buf).Encode(config); err != nil {
+			return nil, err
+		}
+
+	default:
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(config); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jsonMember is one key of a jsonObject.
+type jsonMember struct {
+	key   string
+	value any
+}
+
+// jsonObject is a JSON object that keeps its keys in order.
+type jsonObject []jsonMember
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, member := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(member.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(member.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonMarshaler is implemented by types that encode themselves.
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// omitEmptySections returns v ready for encoding/json, with omitempty struct
+// fields left out when they are zero, as YAML and TOML do. encoding/json
+// writes those as {}. A set pointer is kept even if what it points to is
+// empty, since security: {} turns on the default checks.
+func omitEmptySections(v reflect.Value) any {
+	if v.Kind() == reflect.Pointer && !v.IsNil() && !v.Type().Implements(jsonMarshaler) {
+		return omitEmptySections(v.Elem())
+	}
+	if v.Kind() != reflect.Struct || v.Type().Implements(jsonMarshaler) {
+		return v.Interface()
+	}
+
+	object := jsonObject{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		value := v.Field(i)
+		if opts == "omitempty" && isEmptyJSON(value) {
+			continue
+		}
+		object = append(object, jsonMember{key: name, value: omitEmptySections(value)})
+	}
+	return object
+}
+
+// isEmptyJSON reports whether an omitempty field is left out: a zero value,
+// an empty slice or map, or a zero struct.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
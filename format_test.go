@@ -0,0 +1,233 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const formatYAMLContent = `
+version: 3
+vars:
+  team: platform
+casks:
+  - google-chrome
+formulae:
+  - git
+appStore:
+  apps:
+    - 409201541
+dock:
+  add:
+    - "/Applications/{{ .team }}.app"
+`
+
+const formatJSONContent = `{
+	"version": 3,
+	"vars": {"team": "platform"},
+	"casks": ["google-chrome"],
+	"formulae": ["git"],
+	"appStore": {"apps": [409201541]},
+	"dock": {"add": ["\/Applications\/{{ .team }}.app"]}
+}
+`
+
+const formatTOMLContent = `
+version = 3
+casks = ["google-chrome"]
+formulae = ["git"]
+
+[appStore]
+apps = [409201541]
+
+[vars]
+team = "platform"
+
+[dock]
+add = ["/Applications/{{ .team }}.app"]
+`
+
+func TestDetectConfigFormat(t *testing.T) {
+	tests := []struct {
+		filename string
+		override string
+		want     string
+		wantErr  bool
+	}{
+		{"deploy_config.yml", "", formatYAML, false},
+		{"deploy_config.yaml", "", formatYAML, false},
+		{"deploy_config.json", "", formatJSON, false},
+		{"deploy_config.TOML", "", formatTOML, false},
+		{"deploy_config", "", formatYAML, false},
+		{"deploy_config.txt", "json", formatJSON, false},
+		{"deploy_config.json", "toml", formatTOML, false},
+		{"deploy_config.yml", "ini", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := detectConfigFormat(tt.filename, tt.override)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("detectConfigFormat(%q, %q) = %q, %v; want %q", tt.filename, tt.override, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeConfigFormatsAreEquivalent(t *testing.T) {
+	want, err := decodeConfig([]byte(formatYAMLContent), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for format, content := range map[string]string{formatJSON: formatJSONContent, formatTOML: formatTOMLContent} {
+		got, err := decodeConfig([]byte(content), format)
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestDecodeConfigRejectsUnknownKeysInAllFormats(t *testing.T) {
+	documents := map[string]string{
+		formatYAML: "cask:\n  - arc\n",
+		formatJSON: `{"cask": ["arc"]}`,
+		formatTOML: `cask = ["arc"]`,
+	}
+
+	for format, content := range documents {
+		_, err := decodeConfig([]byte(content), format)
+		if err == nil || !strings.Contains(err.Error(), `unknown key "cask"`) {
+			t.Errorf("%s: expected unknown key error, got %v", format, err)
+		}
+	}
+}
+
+func TestValidateConfigJSONPositions(t *testing.T) {
+	content := `{
+  "casks": ["arc", "arc"],
+  "formula": ["git"]
+}`
+
+	problems := validateConfig([]byte(content), formatJSON)
+	want := []string{
+		`2:20: duplicate cask "arc" (first listed at line 2)`,
+		`3:3: unknown key "formula" in config (did you mean "formulae"?)`,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), problems)
+	}
+	for i, w := range want {
+		if problems[i].String() != w {
+			t.Errorf("Expected %q, got %q", w, problems[i])
+		}
+	}
+}
+
+func TestValidateConfigTOMLPositions(t *testing.T) {
+	content := `version = 3
+casks = ["arc",
+  "arc"]
+formula = ["git"]
+
+[[runtimes]]
+manager = "pyenv"
+
+[[runtimes]]
+manager = "dotnet"
+default = "8"
+
+[dock]
+replace = [{ app = "/Applications/Arc.app", with = "Safari" }]
+`
+
+	problems := validateConfig([]byte(content), formatTOML)
+	want := []string{
+		`3:3: duplicate cask "arc" (first listed at line 2)`,
+		`4:1: unknown key "formula" in config (did you mean "formulae"?)`,
+		`11:11: dotnet cannot set a default version`,
+	}
+	if len(problems) < len(want) {
+		t.Fatalf("Expected at least %d problems, got %v", len(want), problems)
+	}
+	for i, w := range want {
+		if problems[i].String() != w {
+			t.Errorf("Expected %q, got %q", w, problems[i])
+		}
+	}
+	for _, problem := range problems[len(want):] {
+		if problem.Line != 14 {
+			t.Errorf("Expected the dock.replace problems on line 14, got %q", problem)
+		}
+	}
+}
+
+func TestConfigProblemWithoutPosition(t *testing.T) {
+	problem := configProblem{Message: "something is wrong"}
+	if got := problem.in("deploy_config.toml"); got != "deploy_config.toml: something is wrong" {
+		t.Errorf("Unexpected %q", got)
+	}
+	problem = configProblem{Line: 3, Column: 5, Message: "something is wrong"}
+	if got := problem.in("deploy_config.toml"); got != "deploy_config.toml:3:5: something is wrong" {
+		t.Errorf("Unexpected %q", got)
+	}
+}
+
+func TestValidateConfigSyntaxErrorPositions(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+		line    int
+	}{
+		{formatJSON, "{\n  \"casks\": [\"arc\",]\n}", 2},
+		{formatTOML, "casks = [\"arc\"]\nformulae = [\"git\" \"vim\"]\n", 2},
+	}
+
+	for _, tt := range tests {
+		problems := validateConfig([]byte(tt.content), tt.format)
+		if len(problems) != 1 || problems[0].Line != tt.line {
+			t.Errorf("%s: expected one problem on line %d, got %v", tt.format, tt.line, problems)
+		}
+	}
+}
+
+func TestEncodeConfigRoundTrip(t *testing.T) {
+	want, err := decodeConfig([]byte(formatYAMLContent), formatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{formatYAML, formatJSON, formatTOML} {
+		data, err := encodeConfig(want, format)
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", format, err)
+			continue
+		}
+		got, err := decodeConfig(data, format)
+		if err != nil {
+			t.Errorf("%s: expected no error decoding %s, got %v", format, data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: round trip got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestEncodeConfigJSONOmitsUnsetSections(t *testing.T) {
+	config, err := decodeConfig([]byte("version: 3\nformulae:\n  - git\nsecurity: {}\n"), formatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := encodeConfig(config, formatJSON)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := "{\n  \"version\": 3,\n  \"formulae\": [\n    \"git\"\n  ],\n  \"security\": {}\n}\n"
+	if string(data) != want {
+		t.Errorf("Expected %q, got %q", want, data)
+	}
+}
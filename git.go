@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GitConfig is the global git configuration. The named fields are shortcuts
+// for common keys; Config sets any other key, written as section.name. Name
+// and Email are applied by setupGitLogin, which prompts for them when they
+// are not given.
+type GitConfig struct {
+	Name          string            `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty"`
+	Email         string            `yaml:"email,omitempty" json:"email,omitempty" toml:"email,omitempty"`
+	DefaultBranch string            `yaml:"defaultBranch,omitempty" json:"defaultBranch,omitempty" toml:"defaultBranch,omitempty"`
+	PullRebase    string            `yaml:"pullRebase,omitempty" json:"pullRebase,omitempty" toml:"pullRebase,omitempty"`
+	Editor        string            `yaml:"editor,omitempty" json:"editor,omitempty" toml:"editor,omitempty"`
+	Aliases       map[string]string `yaml:"aliases,omitempty" json:"aliases,omitempty" toml:"aliases,omitempty"`
+	Config        map[string]string `yaml:"config,omitempty" json:"config,omitempty" toml:"config,omitempty"`
+	IncludeIf     []GitInclude      `yaml:"includeIf,omitempty" json:"includeIf,omitempty" toml:"includeIf,omitempty"`
+	Signing       *GitSigning       `yaml:"signing,omitempty" json:"signing,omitempty" toml:"signing,omitempty"`
+}
+
+// GitInclude includes the git config file at Path when Condition holds, e.g.
+// "gitdir:~/work/" for repositories under ~/work. Config is written to that
+// file, which is how per-directory identities are set up.
+type GitInclude struct {
+	Condition string            `yaml:"condition" json:"condition" toml:"condition"`
+	Path      string            `yaml:"path" json:"path" toml:"path"`
+	Config    map[string]string `yaml:"config,omitempty" json:"config,omitempty" toml:"config,omitempty"`
+}
+
+// Environment variables that set the git identity without prompting.
+const (
+	gitNameEnv  = "GOMACDEPLOY_GIT_NAME"
+	gitEmailEnv = "GOMACDEPLOY_GIT_EMAIL"
+)
+
+// gitIdentity is the user.name and user.email to set. Empty fields are
+// prompted for.
+type gitIdentity struct {
+	Name  string
+	Email string
+}
+
+// resolveGitIdentity picks each of the name and email from the first source
+// that sets it: the command line flag, the environment, then the config.
+func resolveGitIdentity(flagName, flagEmail string, config GitConfig) gitIdentity {
+	first := func(values ...string) string {
+		for _, value := range values {
+			if value = strings.TrimSpace(value); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	return gitIdentity{
+		Name:  first(flagName, os.Getenv(gitNameEnv), config.Name),
+		Email: first(flagEmail, os.Getenv(gitEmailEnv), config.Email),
+	}
+}
+
+func validGitName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("name is empty")
+	}
+	return nil
+}
+
+// validGitEmail accepts a bare address such as me@example.com.
+func validGitEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("%q is not an email address", email)
+	}
+	if _, domain, _ := strings.Cut(email, "@"); !strings.Contains(domain, ".") {
+		return fmt.Errorf("%q has no domain", email)
+	}
+	return nil
+}
+
+// gitSetting is one key to set in a git config file. An empty file means the
+// global config.
+type gitSetting struct {
+	File  string
+	Key   string
+	Value string
+}
+
+// gitChange records a setting that configureGit changed.
+type gitChange struct {
+	gitSetting
+	Old string
+}
+
+func (c gitChange) String() string {
+	key := c.Key
+	if c.File != "" {
+		key = fmt.Sprintf("%s (%s)", c.Key, c.File)
+	}
+	if c.Old == "" {
+		return fmt.Sprintf("+ %s = %s", key, c.Value)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", key, c.Old, c.Value)
+}
+
+// settings returns every key the config sets, in the order they are applied.
+func (g GitConfig) settings() []gitSetting {
+	var settings []gitSetting
+	add := func(file, key, value string) {
+		if value != "" {
+			settings = append(settings, gitSetting{File: file, Key: key, Value: value})
+		}
+	}
+
+	add("", "init.defaultBranch", g.DefaultBranch)
+	add("", "pull.rebase", g.PullRebase)
+	add("", "core.editor", g.Editor)
+	for _, name := range sortedKeys(g.Aliases) {
+		add("", "alias."+name, g.Aliases[name])
+	}
+	for _, key := range sortedKeys(g.Config) {
+		add("", key, g.Config[key])
+	}
+	for _, include := range g.IncludeIf {
+		add("", fmt.Sprintf("includeIf.%s.path", include.Condition), include.Path)
+		for _, key := range sortedKeys(include.Config) {
+			add(include.Path, key, include.Config[key])
+		}
+	}
+	return settings
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandHome replaces a leading ~/ with the home directory.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(os.Getenv("HOME"), rest)
+	}
+	return path
+}
+
+// gitConfigArgs returns the git config arguments that select a setting's file.
+func gitConfigArgs(file string, args ...string) []string {
+	if file == "" {
+		return append([]string{"config", "--global"}, args...)
+	}
+	return append([]string{"config", "--file", expandHome(file)}, args...)
+}
+
+// configureGit applies the git section. Keys that already have the wanted
+// value are left alone, so running it again changes nothing. It returns the
+// settings it changed.
+func configureGit(r Runner, git GitConfig) ([]gitChange, error) {
+	return applyGitSettings(r, git.settings())
+}
+
+// applyGitSettings sets each key that does not already have the wanted value
+// and returns the settings it changed.
+func applyGitSettings(r Runner, settings []gitSetting) ([]gitChange, error) {
+	var changes []gitChange
+	for _, setting := range settings {
+		current, err := r.Output("git", gitConfigArgs(setting.File, "--get", setting.Key)...)
+		if err != nil {
+			// git config --get fails when the key or the file does not exist.
+			current = ""
+		}
+		current = strings.TrimSpace(current)
+		if current == setting.Value {
+			continue
+		}
+
+		if setting.File != "" {
+			if err := os.MkdirAll(filepath.Dir(expandHome(setting.File)), 0755); err != nil {
+				return changes, err
+			}
+		}
+		if err := r.Run("git", gitConfigArgs(setting.File, "--replace-all", setting.Key, setting.Value)...); err != nil {
+			return changes, fmt.Errorf("setting %s: %v", setting.Key, err)
+		}
+		changes = append(changes, gitChange{gitSetting: setting, Old: current})
+	}
+	return changes, nil
+}
+
+// applyGitConfig runs configureGit and prints what it changed.
+func applyGitConfig(r Runner, git GitConfig) {
+	if len(git.settings()) == 0 {
+		return
+	}
+
+	fmt.Println("Applying git configuration...")
+	changes, err := configureGit(r, git)
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if err != nil {
+		fmt.Printf("Error applying git configuration: %v\n", err)
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Println("Git configuration is already up to date.")
+	}
+}
+
+// checkGit reports git config keys that are not of the form section.name,
+// includeIf entries missing their condition or path, an invalid email and an
+// unknown signing format.
+func checkGit(root *yaml.Node, problems *[]configProblem) {
+	git := mappingValue(root, "git")
+	if git == nil {
+		return
+	}
+
+	checkKeys := func(config *yaml.Node, path string) {
+		if config == nil || config.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(config.Content); i += 2 {
+			key := config.Content[i]
+			if section, name, ok := strings.Cut(key.Value, "."); !ok || section == "" || name == "" || strings.HasSuffix(name, ".") {
+				*problems = append(*problems, newProblem(key, "%s key %q must have the form section.name", path, key.Value))
+			}
+		}
+	}
+	checkKeys(mappingValue(git, "config"), "git.config")
+
+	if signing := mappingValue(git, "signing"); signing != nil {
+		if format := mappingValue(signing, "format"); format != nil && format.Value != signingSSH && format.Value != signingGPG {
+			*problems = append(*problems, newProblem(format, "git.signing.format must be ssh or gpg, got %q", format.Value))
+		}
+	}
+
+	if email := mappingValue(git, "email"); email != nil && email.Kind == yaml.ScalarNode && !strings.Contains(email.Value, "{{") {
+		if err := validGitEmail(email.Value); err != nil {
+			*problems = append(*problems, newProblem(email, "git.email: %v", err))
+		}
+	}
+
+	includes := mappingValue(git, "includeIf")
+	if includes == nil || includes.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range includes.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		for _, key := range []string{"condition", "path"} {
+			if value := mappingValue(item, key); value == nil || strings.TrimSpace(value.Value) == "" {
+				*problems = append(*problems, newProblem(item, "git.includeIf entry needs a non-empty %q", key))
+			}
+		}
+		checkKeys(mappingValue(item, "config"), "git.includeIf config")
+	}
+}
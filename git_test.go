@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const gitConfigContent = `
+version: 3
+git:
+  defaultBranch: main
+  pullRebase: true
+  editor: nvim
+  aliases:
+    st: status
+    co: checkout
+  config:
+    push.autoSetupRemote: "true"
+  includeIf:
+    - condition: "gitdir:~/work/"
+      path: ~/.gitconfig-work
+      config:
+        user.email: me@work.example.com
+`
+
+func TestDecodeGitConfig(t *testing.T) {
+	config, err := decodeConfig([]byte(gitConfigContent), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	want := []gitSetting{
+		{Key: "init.defaultBranch", Value: "main"},
+		{Key: "pull.rebase", Value: "true"},
+		{Key: "core.editor", Value: "nvim"},
+		{Key: "alias.co", Value: "checkout"},
+		{Key: "alias.st", Value: "status"},
+		{Key: "push.autoSetupRemote", Value: "true"},
+		{Key: "includeIf.gitdir:~/work/.path", Value: "~/.gitconfig-work"},
+		{File: "~/.gitconfig-work", Key: "user.email", Value: "me@work.example.com"},
+	}
+	if got := config.Git.settings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateGitConfig(t *testing.T) {
+	content := `version: 3
+git:
+  email: me@localhost
+  config:
+    autocrlf: input
+  includeIf:
+    - condition: "gitdir:~/work/"
+      config:
+        useremail: me@work.example.com
+`
+	want := []string{
+		`3:10: git.email: "me@localhost" has no domain`,
+		`5:5: git.config key "autocrlf" must have the form section.name`,
+		`7:7: git.includeIf entry needs a non-empty "path"`,
+		`9:9: git.includeIf config key "useremail" must have the form section.name`,
+	}
+
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), problems)
+	}
+	for i, w := range want {
+		if problems[i].String() != w {
+			t.Errorf("Problem %d: expected %q, got %q", i, w, problems[i])
+		}
+	}
+}
+
+func TestConfigureGit(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	workConfig := filepath.Join(home, ".gitconfig-work")
+
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --get init.defaultBranch": {output: "main\n"},
+		"git config --global --get pull.rebase":        {output: "false\n"},
+		"git config --global --get core.editor":        {err: errors.New("exit status 1")},
+	})
+
+	git := GitConfig{
+		DefaultBranch: "main",
+		PullRebase:    "true",
+		Editor:        "nvim",
+		IncludeIf: []GitInclude{{
+			Condition: "gitdir:~/work/",
+			Path:      "~/.gitconfig-work",
+			Config:    map[string]string{"user.email": "me@work.example.com"},
+		}},
+	}
+
+	changes, err := configureGit(r, git)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.String())
+	}
+	want := []string{
+		"~ pull.rebase: false -> true",
+		"+ core.editor = nvim",
+		"+ includeIf.gitdir:~/work/.path = ~/.gitconfig-work",
+		"+ user.email (~/.gitconfig-work) = me@work.example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if r.called("git config --global --replace-all init.defaultBranch main") {
+		t.Error("Expected an unchanged key not to be written")
+	}
+	if !r.called("git config --file " + workConfig + " --replace-all user.email me@work.example.com") {
+		t.Errorf("Expected the include file to be written, calls: %v", r.calls)
+	}
+}
+
+func TestConfigureGitIdempotent(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --get alias.st": {output: "status\n"},
+	})
+
+	changes, err := configureGit(r, GitConfig{Aliases: map[string]string{"st": "status"}})
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v, %v", changes, err)
+	}
+	for _, call := range r.calls {
+		if strings.Contains(call, "--replace-all") {
+			t.Errorf("Expected nothing to be written, got %s", call)
+		}
+	}
+}
+
+func TestConfigureGitError(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --replace-all core.editor nvim": {err: errors.New("exit status 255")},
+	})
+
+	changes, err := configureGit(r, GitConfig{DefaultBranch: "main", Editor: "nvim"})
+	if err == nil || !strings.Contains(err.Error(), "core.editor") {
+		t.Errorf("Expected the failing key in the error, got %v", err)
+	}
+	if len(changes) != 1 {
+		t.Errorf("Expected the change made before the failure, got %v", changes)
+	}
+}
+
+func TestSetupGitLoginKeepsNameStillAsksEmail(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.name": {output: "Existing Name\n"},
+	})
+
+	setupGitLogin(r, strings.NewReader("n\nme@example.com\n"), gitIdentity{})
+
+	if r.called("git config --global user.name Existing Name") {
+		t.Error("Expected the existing name to be kept")
+	}
+	if !r.called("git config --global user.email me@example.com") {
+		t.Errorf("Expected the email to be set after keeping the name, calls: %v", r.calls)
+	}
+	if !r.called("git config --global color.ui true") {
+		t.Error("Expected color.ui to be set")
+	}
+}
+
+func TestSetupGitLoginNonInteractive(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.name":  {output: "Old Name\n"},
+		"git config --global --get user.email": {output: "me@example.com\n"},
+	})
+
+	// Nothing on stdin: a prompt would leave the values unset.
+	setupGitLogin(r, strings.NewReader(""), gitIdentity{Name: "New Name", Email: "me@example.com"})
+
+	if !r.called("git config --global user.name New Name") {
+		t.Errorf("Expected the name to be overwritten without asking, calls: %v", r.calls)
+	}
+	if r.called("git config --global user.email me@example.com") {
+		t.Error("Expected an unchanged email not to be written")
+	}
+}
+
+func TestSetupGitLoginValidatesEmail(t *testing.T) {
+	r := newFakeRunner(nil)
+
+	setupGitLogin(r, strings.NewReader("Jane Doe\nnot-an-email\njane@example.com\n"), gitIdentity{Email: "jane@localhost"})
+
+	if !r.called("git config --global user.name Jane Doe") {
+		t.Errorf("Expected the name to be set, calls: %v", r.calls)
+	}
+	if !r.called("git config --global user.email jane@example.com") {
+		t.Errorf("Expected the invalid emails to be rejected and re-prompted, calls: %v", r.calls)
+	}
+	for _, call := range r.calls {
+		if strings.Contains(call, "not-an-email") || strings.Contains(call, "jane@localhost") {
+			t.Errorf("Expected invalid email not to be set, got %s", call)
+		}
+	}
+}
+
+func TestSetupGitLoginNoInput(t *testing.T) {
+	r := newFakeRunner(nil)
+
+	setupGitLogin(r, strings.NewReader(""), gitIdentity{})
+
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "git config --global user.") {
+			t.Errorf("Expected nothing to be set without input, got %s", call)
+		}
+	}
+}
+
+func TestResolveGitIdentity(t *testing.T) {
+	config := GitConfig{Name: "Config Name", Email: "config@example.com"}
+
+	t.Setenv(gitNameEnv, "")
+	t.Setenv(gitEmailEnv, "env@example.com")
+
+	got := resolveGitIdentity("", "", config)
+	if got != (gitIdentity{Name: "Config Name", Email: "env@example.com"}) {
+		t.Errorf("Expected env to win over config, got %+v", got)
+	}
+
+	got = resolveGitIdentity("Flag Name", "flag@example.com", config)
+	if got != (gitIdentity{Name: "Flag Name", Email: "flag@example.com"}) {
+		t.Errorf("Expected flags to win, got %+v", got)
+	}
+}
+
+func TestValidGitEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"me@example.com", true},
+		{"first.last+git@mail.example.co.uk", true},
+		{"", false},
+		{"me", false},
+		{"me@localhost", false},
+		{"Me <me@example.com>", false},
+		{"me @example.com", false},
+	}
+	for _, tt := range tests {
+		if err := validGitEmail(tt.email); (err == nil) != tt.valid {
+			t.Errorf("validGitEmail(%q): got %v, want valid=%v", tt.email, err, tt.valid)
+		}
+	}
+}
@@ -2,4 +2,7 @@ module gomacdeploy
 
 go 1.23.2
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	github.com/BurntSushi/toml v1.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
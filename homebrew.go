@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where the Homebrew installers are downloaded from. The script URL takes a
+// commit of the Homebrew/install repository.
+const (
+	homebrewScriptURL = "https://raw.githubusercontent.com/Homebrew/install/%s/install.sh"
+	homebrewPkgURL    = "https://github.com/Homebrew/brew/releases/latest/download/Homebrew.pkg"
+)
+
+// homebrewTeamID is the Apple Developer team that signs Homebrew.pkg.
+const homebrewTeamID = "6248TWFRH6"
+
+// HomebrewConfig controls how Homebrew is installed when it is missing.
+//
+// The "script" installer downloads install.sh from the Homebrew/install
+// commit Ref and checks it against SHA256; at least one of them must be set.
+// The "pkg" installer downloads the signed Homebrew.pkg, checks its
+// signature and, if set, SHA256. Without an installer, the script is used
+// when it is pinned and the pkg otherwise. URL replaces the download URL,
+// e.g. for a mirror.
+type HomebrewConfig struct {
+	Installer HomebrewInstaller `yaml:"installer,omitempty" json:"installer,omitempty" toml:"installer,omitempty"`
+	Ref       string            `yaml:"ref,omitempty" json:"ref,omitempty" toml:"ref,omitempty"`
+	SHA256    string            `yaml:"sha256,omitempty" json:"sha256,omitempty" toml:"sha256,omitempty"`
+	URL       string            `yaml:"url,omitempty" json:"url,omitempty" toml:"url,omitempty"`
+}
+
+// HomebrewInstaller is "script" or "pkg".
+type HomebrewInstaller string
+
+const (
+	homebrewInstallerScript HomebrewInstaller = "script"
+	homebrewInstallerPkg    HomebrewInstaller = "pkg"
+)
+
+func (i *HomebrewInstaller) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	switch installer := HomebrewInstaller(value); installer {
+	case homebrewInstallerScript, homebrewInstallerPkg:
+		*i = installer
+		return nil
+	}
+	return fmt.Errorf("unknown Homebrew installer %q (use script or pkg)", value)
+}
+
+func (h HomebrewConfig) installer() HomebrewInstaller {
+	switch {
+	case h.Installer != "":
+		return h.Installer
+	case h.Ref != "" || h.SHA256 != "":
+		return homebrewInstallerScript
+	}
+	return homebrewInstallerPkg
+}
+
+func (h HomebrewConfig) url() string {
+	switch {
+	case h.URL != "":
+		return h.URL
+	case h.installer() == homebrewInstallerPkg:
+		return homebrewPkgURL
+	case h.Ref != "":
+		return fmt.Sprintf(homebrewScriptURL, h.Ref)
+	}
+	return fmt.Sprintf(homebrewScriptURL, "HEAD")
+}
+
+// Patterns for the pinned values. Only a full commit ID pins the script;
+// branches and tags can move.
+var (
+	commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+)
+
+// downloadToTemp saves url to a new temporary file and returns its path and
+// sha256. The caller removes the file.
+func downloadToTemp(client *http.Client, url, pattern string) (string, string, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", fmt.Errorf("downloading %s: %v", url, err)
+	}
+	return file.Name(), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checkSHA256 compares a digest with the pinned one, if there is a pin.
+func checkSHA256(got, want string) error {
+	if want != "" && !strings.EqualFold(got, strings.TrimSpace(want)) {
+		return fmt.Errorf("sha256 mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
+
+// installHomebrew installs Homebrew if brew is not on the PATH. The installer
+// is downloaded to a temporary file and verified before it runs.
+func installHomebrew(r Runner, client *http.Client, config HomebrewConfig) error {
+	clearScreen()
+	fmt.Println("Checking if Homebrew is installed...")
+	if commandWorks(r, "brew", "--version") {
+		fmt.Println("Homebrew is already installed.")
+		return nil
+	}
+
+	switch config.installer() {
+	case homebrewInstallerScript:
+		return installHomebrewScript(r, client, config)
+	default:
+		return installHomebrewPkg(r, client, config)
+	}
+}
+
+// installHomebrewScript runs install.sh from a pinned commit or with a pinned
+// digest.
+func installHomebrewScript(r Runner, client *http.Client, config HomebrewConfig) error {
+	// A branch or tag can move, so only a full commit ID or a digest pins
+	// what is run.
+	if !commitPattern.MatchString(config.Ref) && !sha256Pattern.MatchString(config.SHA256) {
+		return fmt.Errorf("the Homebrew install script is not pinned: set homebrew.ref to a 40-character commit ID or homebrew.sha256 to a digest")
+	}
+
+	url := config.url()
+	fmt.Printf("Downloading %s...\n", url)
+	path, sum, err := downloadToTemp(client, url, "homebrew-install-*.sh")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := checkSHA256(sum, config.SHA256); err != nil {
+		return fmt.Errorf("rejecting %s: %v", url, err)
+	}
+
+	fmt.Println("Installing Homebrew...")
+	return r.Run("env", "NONINTERACTIVE=1", "/bin/bash", path)
+}
+
+var (
+	// pkgStatusPattern matches the status pkgutil reports for a package
+	// signed with a Developer ID certificate that chains to Apple.
+	pkgStatusPattern = regexp.MustCompile(`(?m)^\s*Status: signed by a developer certificate issued by Apple for distribution\s*$`)
+	// pkgSignerPattern matches the leaf of the certificate chain and captures
+	// its team ID.
+	pkgSignerPattern = regexp.MustCompile(`(?m)^\s*1\. Developer ID Installer: .* \(([A-Z0-9]{10})\)\s*$`)
+)
+
+// checkPkgSignature checks the output of pkgutil --check-signature: the
+// package must be signed with a Developer ID Installer certificate issued by
+// Apple, and that certificate must belong to Homebrew's team.
+func checkPkgSignature(output string) error {
+	if !pkgStatusPattern.MatchString(output) {
+		return fmt.Errorf("not signed with a Developer ID certificate issued by Apple")
+	}
+	m := pkgSignerPattern.FindStringSubmatch(output)
+	if m == nil || m[1] != homebrewTeamID {
+		return fmt.Errorf("not signed by Homebrew (team %s)", homebrewTeamID)
+	}
+	return nil
+}
+
+// installHomebrewPkg installs Homebrew.pkg after checking that Homebrew's
+// Developer ID certificate signed it and that Gatekeeper accepts it.
+func installHomebrewPkg(r Runner, client *http.Client, config HomebrewConfig) error {
+	url := config.url()
+	fmt.Printf("Downloading %s...\n", url)
+	path, sum, err := downloadToTemp(client, url, "Homebrew-*.pkg")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := checkSHA256(sum, config.SHA256); err != nil {
+		return fmt.Errorf("rejecting %s: %v", url, err)
+	}
+
+	out, err := r.Output("pkgutil", "--check-signature", path)
+	if err != nil {
+		return fmt.Errorf("rejecting %s: checking the signature: %v", url, err)
+	}
+	if err := checkPkgSignature(out); err != nil {
+		return fmt.Errorf("rejecting %s: %v", url, err)
+	}
+	if _, err := r.Output("spctl", "--assess", "--type", "install", path); err != nil {
+		return fmt.Errorf("rejecting %s: Gatekeeper does not accept it: %v", url, err)
+	}
+
+	fmt.Println("Installing Homebrew...")
+	return r.Run("sudo", "installer", "-pkg", path, "-target", "/")
+}
+
+// setupHomebrew adds Homebrew's shell setup to profile for new shells and
+// loads `brew shellenv` into gomacdeploy's own environment, so the following
+// steps find brew and everything it installs during this run.
+func setupHomebrew(r Runner, profile string) error {
+	clearScreen()
+	brew := filepath.Join(brewPrefix(), "bin", "brew")
+	if err := addHomebrewToProfile(profile, brew); err != nil {
+		return err
+	}
+
+	exports, err := loadShellEnv(r, brew, "shellenv")
+	if err != nil {
+		return fmt.Errorf("running brew shellenv: %v", err)
+	}
+	// Newer versions of brew set PATH through path_helper instead of an
+	// export line, so make sure Homebrew's directories are on it either way.
+	prefix := brewPrefix()
+	for _, export := range exports {
+		if export.Name == "HOMEBREW_PREFIX" {
+			prefix = export.Value
+		}
+	}
+	prependPath(filepath.Join(prefix, "bin"), filepath.Join(prefix, "sbin"))
+	fmt.Println("Loaded the Homebrew environment.")
+	return nil
+}
+
+// addHomebrewToProfile appends the brew shellenv line to profile unless it is
+// already there.
+func addHomebrewToProfile(profile, brew string) error {
+	homebrewInit := fmt.Sprintf(`eval "$(%s shellenv)"`, brew)
+	data, err := os.ReadFile(profile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if strings.Contains(string(data), homebrewInit) {
+		fmt.Printf("Homebrew initialization is already in %s.\n", profile)
+		return nil
+	}
+
+	file, err := os.OpenFile(profile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(homebrewInit + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkHomebrew reports pins that do not pin anything and a script installer
+// without a pin.
+func checkHomebrew(root *yaml.Node, problems *[]configProblem) {
+	section := mappingValue(root, "homebrew")
+	if section == nil || section.Kind != yaml.MappingNode {
+		return
+	}
+	ref := mappingValue(section, "ref")
+	sum := mappingValue(section, "sha256")
+
+	templated := func(node *yaml.Node) bool { return strings.Contains(node.Value, "{{") }
+	if ref != nil && !templated(ref) && !commitPattern.MatchString(ref.Value) {
+		*problems = append(*problems, newProblem(ref, "homebrew.ref must be a full 40-character commit ID, got %q", ref.Value))
+	}
+	if sum != nil && !templated(sum) && !sha256Pattern.MatchString(sum.Value) {
+		*problems = append(*problems, newProblem(sum, "homebrew.sha256 must be 64 hex characters, got %q", sum.Value))
+	}
+	if installer := mappingValue(section, "installer"); installer != nil && installer.Value == string(homebrewInstallerScript) && ref == nil && sum == nil {
+		*problems = append(*problems, newProblem(installer, "homebrew.installer script needs \"ref\" or \"sha256\""))
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+const homebrewScript = "#!/bin/bash\necho installing homebrew\n"
+
+// scriptRunner records the contents of the downloaded installer when it is
+// run, since the file is removed afterwards.
+type scriptRunner struct {
+	*fakeRunner
+	ran []string
+}
+
+func (s *scriptRunner) Run(name string, args ...string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, os.TempDir()) {
+			continue
+		}
+		if data, err := os.ReadFile(arg); err == nil {
+			s.ran = append(s.ran, string(data))
+		}
+	}
+	return s.fakeRunner.Run(name, args...)
+}
+
+func newBrewMissingRunner(results map[string]fakeResult) *scriptRunner {
+	if results == nil {
+		results = map[string]fakeResult{}
+	}
+	results["brew --version"] = fakeResult{err: errors.New("executable file not found")}
+	return &scriptRunner{fakeRunner: newFakeRunner(results)}
+}
+
+func TestInstallHomebrewScript(t *testing.T) {
+	server := newConfigServer(t, map[string]string{"/install.sh": homebrewScript})
+	config := HomebrewConfig{SHA256: sha256Hex(homebrewScript), URL: server.URL + "/install.sh"}
+
+	r := newBrewMissingRunner(nil)
+	if err := installHomebrew(r, server.Client(), config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(r.ran) != 1 || r.ran[0] != homebrewScript {
+		t.Fatalf("Expected the downloaded script to be run, got %v", r.ran)
+	}
+	last := r.calls[len(r.calls)-1]
+	if !strings.HasPrefix(last, "env NONINTERACTIVE=1 /bin/bash ") {
+		t.Errorf("Expected the script to run non-interactively, got %s", last)
+	}
+	if _, err := os.Stat(strings.Fields(last)[3]); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary script to be removed, got %v", err)
+	}
+}
+
+func TestInstallHomebrewScriptRejected(t *testing.T) {
+	server := newConfigServer(t, map[string]string{"/install.sh": homebrewScript})
+
+	r := newBrewMissingRunner(nil)
+	config := HomebrewConfig{SHA256: sha256Hex("something else"), URL: server.URL + "/install.sh"}
+	if err := installHomebrew(r, server.Client(), config); err == nil || !strings.Contains(err.Error(), "sha256 mismatch") {
+		t.Errorf("Expected a digest mismatch, got %v", err)
+	}
+	if len(r.ran) != 0 {
+		t.Errorf("Expected nothing to run, got %v", r.ran)
+	}
+
+	config = HomebrewConfig{Installer: homebrewInstallerScript, URL: server.URL + "/install.sh"}
+	if err := installHomebrew(r, server.Client(), config); err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Errorf("Expected an unpinned script to be refused, got %v", err)
+	}
+
+	// A branch name is not a pin: it can move between runs.
+	config = HomebrewConfig{Installer: homebrewInstallerScript, Ref: "master"}
+	if err := installHomebrew(r, server.Client(), config); err == nil || !strings.Contains(err.Error(), "not pinned") {
+		t.Errorf("Expected ref master without a sha256 to be refused, got %v", err)
+	}
+	if len(r.ran) != 0 {
+		t.Errorf("Expected nothing to run, got %v", r.ran)
+	}
+}
+
+func TestInstallHomebrewPkg(t *testing.T) {
+	server := newConfigServer(t, map[string]string{"/Homebrew.pkg": "xar!"})
+	config := HomebrewConfig{URL: server.URL + "/Homebrew.pkg"}
+
+	signed := "Package \"Homebrew.pkg\":\n   Status: signed by a developer certificate issued by Apple for distribution\n   Certificate Chain:\n    1. Developer ID Installer: Mike McQuaid (6248TWFRH6)\n"
+	r := &signatureRunner{scriptRunner: newBrewMissingRunner(nil), output: signed}
+	if err := installHomebrew(r, server.Client(), config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(r.ran) != 1 || r.ran[0] != "xar!" {
+		t.Errorf("Expected the downloaded pkg to be installed, got %v", r.ran)
+	}
+	last := r.calls[len(r.calls)-1]
+	if !strings.HasPrefix(last, "sudo installer -pkg ") || !strings.HasSuffix(last, " -target /") {
+		t.Errorf("Expected installer to run, got %s", last)
+	}
+
+	assessed := false
+	for _, call := range r.calls {
+		assessed = assessed || strings.HasPrefix(call, "spctl --assess --type install ")
+	}
+	if !assessed {
+		t.Errorf("Expected Gatekeeper to assess the pkg, calls: %v", r.calls)
+	}
+
+	tests := []struct {
+		name      string
+		output    string
+		assessErr error
+		want      string
+	}{
+		{"unsigned", "Package \"Homebrew.pkg\":\n   Status: no signature\n", nil, "not signed with a Developer ID certificate"},
+		{"untrusted", "Package \"Homebrew.pkg\":\n   Status: signed by untrusted certificate\n   Certificate Chain:\n    1. Developer ID Installer: Mike McQuaid (6248TWFRH6)\n", nil, "not signed with a Developer ID certificate"},
+		// The team ID appears, but not on the leaf certificate that signed it.
+		{"other team", "Package \"Homebrew.pkg\":\n   Status: signed by a developer certificate issued by Apple for distribution\n   Certificate Chain:\n    1. Developer ID Installer: Someone Else (ABCDE12345)\n    2. Mike McQuaid (6248TWFRH6)\n", nil, "not signed by Homebrew"},
+		{"rejected by Gatekeeper", signed, errors.New("exit status 3: rejected"), "Gatekeeper does not accept it"},
+	}
+	for _, tt := range tests {
+		r := &signatureRunner{scriptRunner: newBrewMissingRunner(nil), output: tt.output, assessErr: tt.assessErr}
+		if err := installHomebrew(r, server.Client(), config); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected the pkg to be rejected with %q, got %v", tt.name, tt.want, err)
+		}
+		if len(r.ran) != 0 {
+			t.Errorf("%s: expected nothing to be installed, got %v", tt.name, r.ran)
+		}
+	}
+}
+
+// signatureRunner answers pkgutil --check-signature and spctl --assess,
+// whose arguments are a temporary path.
+type signatureRunner struct {
+	*scriptRunner
+	output    string
+	assessErr error
+}
+
+func (s *signatureRunner) Output(name string, args ...string) (string, error) {
+	switch name {
+	case "pkgutil":
+		s.calls = append(s.calls, name+" "+strings.Join(args, " "))
+		return s.output, nil
+	case "spctl":
+		s.calls = append(s.calls, name+" "+strings.Join(args, " "))
+		return "", s.assessErr
+	}
+	return s.scriptRunner.Output(name, args...)
+}
+
+func TestInstallHomebrewAlreadyInstalled(t *testing.T) {
+	r := newFakeRunner(nil)
+	if err := installHomebrew(r, nil, HomebrewConfig{URL: "http://127.0.0.1:1/unused"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(r.calls) != 1 {
+		t.Errorf("Expected only the check to run, got %v", r.calls)
+	}
+}
+
+func TestHomebrewDefaults(t *testing.T) {
+	ref := strings.Repeat("a", 40)
+	tests := []struct {
+		config    HomebrewConfig
+		installer HomebrewInstaller
+		url       string
+	}{
+		{HomebrewConfig{}, homebrewInstallerPkg, homebrewPkgURL},
+		{HomebrewConfig{Ref: ref}, homebrewInstallerScript, "https://raw.githubusercontent.com/Homebrew/install/" + ref + "/install.sh"},
+		{HomebrewConfig{SHA256: strings.Repeat("0", 64)}, homebrewInstallerScript, "https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh"},
+	}
+	for _, test := range tests {
+		if test.config.installer() != test.installer || test.config.url() != test.url {
+			t.Errorf("%+v: got %s %s", test.config, test.config.installer(), test.config.url())
+		}
+	}
+}
+
+func TestValidateHomebrew(t *testing.T) {
+	content := "version: 3\nhomebrew:\n  installer: script\n  ref: main\n  sha256: abc\n"
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != 2 || !strings.Contains(problems[0].String(), "40-character commit") || !strings.Contains(problems[1].String(), "64 hex") {
+		t.Errorf("Expected ref and sha256 problems, got %v", problems)
+	}
+
+	problems = validateConfig([]byte("version: 3\nhomebrew:\n  installer: script\n"), formatYAML)
+	if len(problems) != 1 || !strings.Contains(problems[0].String(), `needs "ref" or "sha256"`) {
+		t.Errorf("Expected a missing pin problem, got %v", problems)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HostConfig names the Mac. The values are templates like the rest of the
+// config, but they are rendered when the run starts rather than when the
+// config is loaded, so they can also use serial, the hardware serial number,
+// and answer, the reply to Prompt.
+//
+// Only ComputerName is needed: LocalHostName defaults to ComputerName with
+// anything but letters, digits and hyphens replaced, HostName to
+// LocalHostName, and NetBIOSName to the first 15 characters of LocalHostName.
+type HostConfig struct {
+	Prompt        string `yaml:"prompt,omitempty" json:"prompt,omitempty" toml:"prompt,omitempty"`
+	ComputerName  string `yaml:"computerName,omitempty" json:"computerName,omitempty" toml:"computerName,omitempty"`
+	HostName      string `yaml:"hostName,omitempty" json:"hostName,omitempty" toml:"hostName,omitempty"`
+	LocalHostName string `yaml:"localHostName,omitempty" json:"localHostName,omitempty" toml:"localHostName,omitempty"`
+	NetBIOSName   string `yaml:"netBIOSName,omitempty" json:"netBIOSName,omitempty" toml:"netBIOSName,omitempty"`
+}
+
+// smbServerPreferences holds the NetBIOS name.
+const smbServerPreferences = "/Library/Preferences/SystemConfiguration/com.apple.smb.server"
+
+var (
+	// localHostNamePattern is what Bonjour accepts as a local host name.
+	localHostNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,63}$`)
+	// serialNumberPattern matches the serial number in ioreg output.
+	serialNumberPattern = regexp.MustCompile(`"IOPlatformSerialNumber" = "([^"]*)"`)
+)
+
+// hostSetting is one of the names and the value it should have.
+type hostSetting struct {
+	name  string
+	value string
+}
+
+// current returns the name as it is now, or "" if it is not set.
+func (h hostSetting) current(r Runner) string {
+	var out string
+	var err error
+	if h.name == "NetBIOSName" {
+		out, err = r.Output("defaults", "read", smbServerPreferences, "NetBIOSName")
+	} else {
+		out, err = r.Output("scutil", "--get", h.name)
+	}
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+func (h hostSetting) apply(r Runner) error {
+	if h.name == "NetBIOSName" {
+		return r.Run("sudo", "defaults", "write", smbServerPreferences, "NetBIOSName", "-string", h.value)
+	}
+	return r.Run("sudo", "scutil", "--set", h.name, h.value)
+}
+
+// localHostName derives a local host name from a computer name the way macOS
+// does: apostrophes are dropped and other characters that are not allowed
+// become hyphens.
+func localHostName(computerName string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, c := range strings.NewReplacer("'", "", "’", "").Replace(computerName) {
+		if c < 128 && (c == '-' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			b.WriteRune(c)
+			hyphen = false
+		} else if !hyphen {
+			b.WriteByte('-')
+			hyphen = true
+		}
+	}
+	name := strings.Trim(b.String(), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// serialNumber returns the Mac's hardware serial number.
+func serialNumber(r Runner) (string, error) {
+	out, err := r.Output("ioreg", "-rd1", "-c", "IOPlatformExpertDevice")
+	if err != nil {
+		return "", err
+	}
+	m := serialNumberPattern.FindStringSubmatch(out)
+	if m == nil || m[1] == "" {
+		return "", fmt.Errorf("no serial number in ioreg output")
+	}
+	return m[1], nil
+}
+
+// resolveHost renders the host names, asking config.Prompt first if it is
+// set, and returns them with the defaults filled in.
+func resolveHost(r Runner, in io.Reader, config HostConfig, vars map[string]string) ([]hostSetting, error) {
+	hostVars := make(map[string]string, len(vars)+2)
+	for name, value := range vars {
+		hostVars[name] = value
+	}
+
+	if config.Prompt != "" {
+		fmt.Printf("%s: ", config.Prompt)
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return nil, fmt.Errorf("no answer to %q", config.Prompt)
+		}
+		hostVars["answer"] = answer
+	}
+
+	values := []*string{&config.ComputerName, &config.HostName, &config.LocalHostName, &config.NetBIOSName}
+	for _, value := range values {
+		if !strings.Contains(*value, ".serial") {
+			continue
+		}
+		serial, err := serialNumber(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading the serial number: %v", err)
+		}
+		hostVars["serial"] = serial
+		break
+	}
+	for _, value := range values {
+		rendered, err := renderString(*value, hostVars)
+		if err != nil {
+			return nil, fmt.Errorf("host: %w", err)
+		}
+		*value = strings.TrimSpace(rendered)
+	}
+
+	if config.LocalHostName == "" {
+		config.LocalHostName = localHostName(config.ComputerName)
+	}
+	if config.HostName == "" {
+		config.HostName = config.LocalHostName
+	}
+	if config.NetBIOSName == "" && config.LocalHostName != "" {
+		config.NetBIOSName = config.LocalHostName
+		if len(config.NetBIOSName) > 15 {
+			config.NetBIOSName = strings.TrimRight(config.NetBIOSName[:15], "-")
+		}
+	}
+
+	if config.LocalHostName != "" && !localHostNamePattern.MatchString(config.LocalHostName) {
+		return nil, fmt.Errorf("local host name %q may only use letters, digits and hyphens", config.LocalHostName)
+	}
+	if len(config.NetBIOSName) > 15 {
+		return nil, fmt.Errorf("NetBIOS name %q is longer than 15 characters", config.NetBIOSName)
+	}
+
+	var settings []hostSetting
+	for _, setting := range []hostSetting{
+		{"ComputerName", config.ComputerName},
+		{"HostName", config.HostName},
+		{"LocalHostName", config.LocalHostName},
+		{"NetBIOSName", config.NetBIOSName},
+	} {
+		if setting.value != "" {
+			settings = append(settings, setting)
+		}
+	}
+	return settings, nil
+}
+
+// pendingHostSettings returns the settings that differ from the Mac's current
+// names.
+func pendingHostSettings(r Runner, settings []hostSetting) []hostSetting {
+	var pending []hostSetting
+	for _, setting := range settings {
+		if setting.current(r) != setting.value {
+			pending = append(pending, setting)
+		}
+	}
+	return pending
+}
+
+// configureHost sets the names that are not already correct.
+func configureHost(r Runner, settings []hostSetting) error {
+	clearScreen()
+	fmt.Println("Naming this Mac...")
+	pending := pendingHostSettings(r, settings)
+	if len(pending) == 0 {
+		fmt.Println("The host names are already set.")
+		return nil
+	}
+
+	var failed []string
+	for _, setting := range pending {
+		fmt.Printf("Setting %s to %s...\n", setting.name, setting.value)
+		if err := setting.apply(r); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", setting.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// checkHost reports host values that use the answer without a prompt and
+// names macOS would reject.
+func checkHost(root *yaml.Node, problems *[]configProblem) {
+	section := mappingValue(root, "host")
+	if section == nil || section.Kind != yaml.MappingNode {
+		return
+	}
+	prompt := mappingValue(section, "prompt")
+	for _, key := range []string{"computerName", "hostName", "localHostName", "netBIOSName"} {
+		value := mappingValue(section, key)
+		if value == nil {
+			continue
+		}
+		if strings.Contains(value.Value, ".answer") && prompt == nil {
+			*problems = append(*problems, newProblem(value, "host.%s uses answer but host.prompt is not set", key))
+		}
+		if strings.Contains(value.Value, "{{") {
+			continue
+		}
+		switch {
+		case key == "localHostName" && !localHostNamePattern.MatchString(value.Value):
+			*problems = append(*problems, newProblem(value, "host.localHostName may only use letters, digits and hyphens, got %q", value.Value))
+		case key == "netBIOSName" && len(value.Value) > 15:
+			*problems = append(*problems, newProblem(value, "host.netBIOSName is longer than 15 characters"))
+		}
+	}
+}
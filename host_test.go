@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ioregPlatform is trimmed `ioreg -rd1 -c IOPlatformExpertDevice` output.
+const ioregPlatform = `+-o J314sAP  <class IOPlatformExpertDevice, id 0x100000233, registered, matched, active, busy 0 (12 ms), retain 37>
+    {
+      "IOPlatformUUID" = "3A1F7A0C-5B6E-4F1D-9C2B-8E7D6A5B4C3D"
+      "model" = <"MacBookPro18,3">
+      "IOPlatformSerialNumber" = "C02ZK1ABMD6T"
+      "manufacturer" = <"Apple Inc.">
+    }
+`
+
+func TestLocalHostName(t *testing.T) {
+	tests := map[string]string{
+		"Jane's MacBook Pro":    "Janes-MacBook-Pro",
+		"Build Mac (2)":         "Build-Mac-2",
+		"  IT—4411  ":           "IT-4411",
+		"mac-C02ZK1ABMD6T":      "mac-C02ZK1ABMD6T",
+		strings.Repeat("a", 70): strings.Repeat("a", 63),
+	}
+	for in, want := range tests {
+		if got := localHostName(in); got != want {
+			t.Errorf("%q: expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestResolveHost(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{"ioreg -rd1 -c IOPlatformExpertDevice": {output: ioregPlatform}})
+	config := HostConfig{
+		Prompt:       "Asset tag",
+		ComputerName: "{{ .team }} Mac {{ .answer }}",
+		HostName:     "mac-{{ .serial }}.example.com",
+	}
+	settings, err := resolveHost(r, strings.NewReader("4411\n"), config, map[string]string{"team": "Platform"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []hostSetting{
+		{"ComputerName", "Platform Mac 4411"},
+		{"HostName", "mac-C02ZK1ABMD6T.example.com"},
+		{"LocalHostName", "Platform-Mac-4411"},
+		{"NetBIOSName", "Platform-Mac-44"},
+	}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("Expected %v, got %v", want, settings)
+	}
+
+	if _, err := resolveHost(r, strings.NewReader("\n"), config, nil); err == nil || !strings.Contains(err.Error(), "no answer") {
+		t.Errorf("Expected an empty answer to fail, got %v", err)
+	}
+	if _, err := resolveHost(r, nil, HostConfig{ComputerName: "Mac", LocalHostName: "my_mac"}, nil); err == nil {
+		t.Error("Expected an invalid local host name to fail")
+	}
+
+	r = newFakeRunner(map[string]fakeResult{"ioreg -rd1 -c IOPlatformExpertDevice": {err: errors.New("executable file not found")}})
+	if _, err := resolveHost(r, nil, HostConfig{ComputerName: "{{ .serial }}"}, nil); err == nil || !strings.Contains(err.Error(), "serial number") {
+		t.Errorf("Expected a missing serial number to fail, got %v", err)
+	}
+}
+
+func TestConfigureHost(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"scutil --get ComputerName":                              {output: "Platform Mac 4411\n"},
+		"scutil --get HostName":                                  {err: errors.New("HostName: not set")},
+		"scutil --get LocalHostName":                             {output: "Platform-Mac-4411\n"},
+		"defaults read " + smbServerPreferences + " NetBIOSName": {output: "OLDNAME\n"},
+	})
+	settings := []hostSetting{
+		{"ComputerName", "Platform Mac 4411"},
+		{"HostName", "Platform-Mac-4411"},
+		{"LocalHostName", "Platform-Mac-4411"},
+		{"NetBIOSName", "Platform-Mac-44"},
+	}
+	if err := configureHost(r, settings); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, call := range []string{
+		"sudo scutil --set HostName Platform-Mac-4411",
+		"sudo defaults write " + smbServerPreferences + " NetBIOSName -string Platform-Mac-44",
+	} {
+		if !r.called(call) {
+			t.Errorf("Expected %q to run, calls: %v", call, r.calls)
+		}
+	}
+	for _, call := range []string{"sudo scutil --set ComputerName Platform Mac 4411", "sudo scutil --set LocalHostName Platform-Mac-4411"} {
+		if r.called(call) {
+			t.Errorf("Expected %q not to run", call)
+		}
+	}
+}
+
+func TestHostIsRenderedLater(t *testing.T) {
+	config := &Config{Host: &HostConfig{ComputerName: "Mac {{ .answer }}"}, Casks: []string{"{{ .arch }}"}}
+	if err := renderConfig(config, map[string]string{"arch": "arm64"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.Host.ComputerName != "Mac {{ .answer }}" || config.Casks[0] != "arm64" {
+		t.Errorf("Expected only the host section to stay a template, got %+v %v", config.Host, config.Casks)
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	content := "version: 3\nhost:\n  computerName: \"Mac {{ .answer }}\"\n  localHostName: my_mac\n  netBIOSName: ABCDEFGHIJKLMNOPQ\n"
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != 3 ||
+		!strings.Contains(problems[0].String(), "host.prompt is not set") ||
+		!strings.Contains(problems[1].String(), "letters, digits and hyphens") ||
+		!strings.Contains(problems[2].String(), "15 characters") {
+		t.Errorf("Expected answer, localHostName and netBIOSName problems, got %v", problems)
+	}
+}
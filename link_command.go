@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// listFlag collects a repeated flag.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// linkCommand implements `gomacdeploy link`, a stow-style symlink farm
+// manager. It links the named packages, or every package in the directory,
+// into the target. Conflicts are reported and nothing is changed.
+func linkCommand(args []string) int {
+	fs := flag.NewFlagSet("link", flag.ExitOnError)
+	dir := fs.String("dir", defaultDotfilesDir, "directory holding the packages")
+	target := fs.String("target", os.Getenv("HOME"), "directory to link the packages into")
+	unlink := fs.Bool("delete", false, "remove the packages' links instead of creating them")
+	relink := fs.Bool("restow", false, "remove and recreate the packages' links")
+	adopt := fs.Bool("adopt", false, "move existing files into the package and link them")
+	noFolding := fs.Bool("no-folding", false, "link files one by one instead of linking whole directories")
+	dryRun := fs.Bool("dry-run", false, "print what would be done without changing anything")
+	var ignore listFlag
+	fs.Var(&ignore, "ignore", "regular expression for paths to skip, matched against the end of the path (repeatable)")
+	fs.Parse(args)
+
+	if *unlink && *relink {
+		fmt.Println("Error: --delete and --restow cannot be combined")
+		return 2
+	}
+	op := stowOpLink
+	switch {
+	case *unlink:
+		op = stowOpUnlink
+	case *relink:
+		op = stowOpRelink
+	}
+
+	stowDir := expandHome(*dir)
+	packages, err := dotfilesPackages(stowDir, fs.Args())
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	opts := stowOptions{
+		dir:       stowDir,
+		target:    expandHome(*target),
+		ignore:    ignore,
+		adopt:     *adopt,
+		noFolding: *noFolding,
+	}
+	actions, conflicts, err := stowPackages(opts, packages, op, *dryRun)
+	if len(conflicts) > 0 {
+		fmt.Println("Conflicts, nothing was changed:")
+		for _, conflict := range conflicts {
+			fmt.Printf("  %s\n", conflict)
+		}
+		return 1
+	}
+
+	prefix := ""
+	if *dryRun {
+		prefix = "would "
+	}
+	for _, action := range actions {
+		fmt.Printf("%s%s\n", prefix, action)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(actions) == 0 {
+		fmt.Println("Nothing to do.")
+	}
+	return 0
+}
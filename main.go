@@ -17,21 +17,30 @@
 // following tasks:
 // - Clears the terminal screen
 // - Prints ASCII art
-// - Prompts for the root password
-// - Keeps sudo alive
-// - Updates macOS
-// - Installs Rosetta (if needed)
-// - Installs Homebrew (if not already installed)
-// - Sets up Homebrew environment
+// - Asks for the root password only when a step that needs sudo runs, and keeps the credentials fresh; with --no-sudo it never asks and skips those steps
+// - Names the Mac from its serial number or an asset tag (if configured)
+// - Updates macOS, deferring updates that need a restart to the end
+// - Installs Rosetta on Apple silicon (if needed)
+// - Installs the Xcode Command Line Tools (if needed)
+// - Installs Homebrew with a verified installer (if not already installed)
+// - Sets up the Homebrew environment, for new shells and for the rest of the run
 // - Checks and updates Homebrew
 // - Installs specified formulae
 // - Installs specified casks
 // - Installs specified Mac App Store applications
-// - Installs .NET (if desired)
+// - Upgrades Mac App Store applications (if configured)
+// - Installs language runtimes (Go, Python, Node.js, Rust, Java, .NET)
 // - Configures default system settings
 // - Configures Dock settings
+// - Enables Touch ID for sudo (if configured)
 // - Sets up Git login
+// - Applies the git configuration
+// - Sets up an SSH key and the SSH config (if configured)
+// - Sets up commit signing (if configured)
+// - Clones, links and renders dotfiles (if configured)
+// - Checks FileVault, the firewall, Gatekeeper and SIP against a security baseline (if configured)
 // - Cleans up Homebrew installations
+// - Prints a summary, including outdated packages
 // - Reboots the system
 //
 // Version: v0.1.6
@@ -43,76 +52,195 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
-	"time"
-
-	"gopkg.in/yaml.v2"
 )
 
 // TODO: Add more error handling
 // TODO: Add more comments
 
-type Config struct {
-	Casks           []string `yaml:"casks"`
-	Formulae        []string `yaml:"formulae"`
-	AppStore        []string `yaml:"appStore"`
-	DefaultSettings []string `yaml:"defaultSettings"`
-	DockReplace     []string `yaml:"dockReplace"`
-	DockAdd         []string `yaml:"dockAdd"`
-	DockRemove      []string `yaml:"dockRemove"`
-}
-
 func main() {
-	config, err := readConfig("deploy_config.yml")
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "validate":
+			os.Exit(validateCommand(args[1:]))
+		case "config":
+			os.Exit(configCommand(args[1:]))
+		case "link":
+			os.Exit(linkCommand(args[1:]))
+		}
+	}
+
+	fs := flag.NewFlagSet("gomacdeploy", flag.ExitOnError)
+	configFlags := addConfigFlags(fs)
+	gitName := fs.String("git-name", "", "git user.name to set without prompting")
+	gitEmail := fs.String("git-email", "", "git user.email to set without prompting")
+	askpass := fs.String("askpass", "", "SUDO_ASKPASS helper that supplies the sudo password")
+	noSudo := fs.Bool("no-sudo", false, "skip the steps that need sudo instead of asking for the password")
+	fs.Parse(args)
+
+	config, vars, err := configFlags.load()
 	if err != nil {
 		fmt.Printf("Error reading config: %v\n", err)
 		os.Exit(1)
 	}
 
+	report := &summaryReport{}
+
 	clearScreen()
 	printASCIIArt()
-	promptForRootPassword()
-	keepSudoAlive()
-	updateMacOS()
-	installRosetta()
-	installHomebrew()
-	setupHomebrew()
-	checkAndUpdateHomebrew()
-	installFormulae(config.Formulae)
-	installCasks(config.Casks)
-	installAppStoreApps(config.AppStore)
-	installDotNet()
-	configureDefaultSettings(config.DefaultSettings)
-	configureDockSettings(config.DockReplace, config.DockAdd, config.DockRemove)
-	setupGitLogin()
-	cleanup()
-	finishAndReboot()
-
-}
-
-func readConfig(filename string) (*Config, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+	sudo := newSudoSession(*askpass)
+	defer sudo.Stop()
+	runner := sudo.Runner(execRunner{})
+	elevate := func() error {
+		if err := sudo.Elevate(context.Background()); err != nil {
+			return fmt.Errorf("sudo is not available: %v", err)
+		}
+		return nil
+	}
+	if *noSudo {
+		elevate = nil
 	}
-	defer file.Close()
 
-	bytes, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
+	var host []hostSetting
+	if config.Host != nil {
+		if host, err = resolveHost(runner, os.Stdin, *config.Host, vars); err != nil {
+			fmt.Printf("Error naming this Mac: %v\n", err)
+			report.add("Host names failed", err.Error())
+		}
 	}
 
-	var config Config
-	err = yaml.Unmarshal(bytes, &config)
-	if err != nil {
-		return nil, err
+	var deferredUpdates []softwareUpdate
+	var appStoreResults []appStoreResult
+	var outdatedApps []masListing
+	var runtimeFailures, editedDotfiles []string
+	steps := []step{
+		{"Host names", len(pendingHostSettings(runner, host)) > 0, func() {
+			if len(host) == 0 {
+				return
+			}
+			if err := configureHost(runner, host); err != nil {
+				fmt.Printf("Error naming this Mac: %v\n", err)
+				report.add("Host names failed", err.Error())
+			}
+		}},
+		{"macOS updates", config.SoftwareUpdate.installs(), func() {
+			deferredUpdates = updateMacOS(runner, config.SoftwareUpdate, report)
+		}},
+		{"Rosetta", rosettaNeeded(runner, config.Rosetta), func() {
+			report.addRosetta(installRosetta(runner, config.Rosetta))
+		}},
+		{"Command Line Tools", commandLineToolsPath(runner) == "", func() {
+			if err := installCommandLineTools(runner); err != nil {
+				fmt.Printf("Error installing the Command Line Tools: %v\n", err)
+				report.add("Command Line Tools failed", err.Error())
+			}
+		}},
+		{"Homebrew install", !commandWorks(runner, "brew", "--version"), func() {
+			if err := installHomebrew(runner, nil, config.Homebrew); err != nil {
+				fmt.Printf("Error installing Homebrew: %v\n", err)
+				report.add("Homebrew install failed", err.Error())
+			}
+		}},
+		{"Homebrew environment", false, func() {
+			if err := setupHomebrew(runner, zprofilePath()); err != nil {
+				fmt.Printf("Error setting up the Homebrew environment: %v\n", err)
+				report.add("Homebrew environment failed", err.Error())
+			}
+		}},
+		{"Homebrew update", false, checkAndUpdateHomebrew},
+		{"Formulae", false, func() { installFormulae(config.Formulae) }},
+		{"Casks", false, func() { installCasks(config.Casks) }},
+		{"App Store apps", false, func() {
+			appStoreResults = installAppStoreApps(runner, config.AppStore.Apps)
+			outdatedApps = upgradeAppStoreApps(runner, config.AppStore, appStoreResults)
+		}},
+		{"Language runtimes", false, func() {
+			runtimeFailures = installRuntimes(runner, config.Runtimes, zprofilePath())
+		}},
+		{"Default settings", settingsNeedRoot(config.DefaultSettings), func() { configureDefaultSettings(config.DefaultSettings) }},
+		{"Dock", false, func() { configureDockSettings(config.Dock) }},
+		{"Touch ID for sudo", config.TouchID != nil && touchIDPending(*config.TouchID), func() {
+			if config.TouchID == nil {
+				return
+			}
+			if err := configureTouchID(runner, *config.TouchID); err != nil {
+				fmt.Printf("Error configuring Touch ID for sudo: %v\n", err)
+				report.add("Touch ID for sudo failed", err.Error())
+			}
+		}},
+		{"Git", false, func() {
+			setupGitLogin(runner, os.Stdin, resolveGitIdentity(*gitName, *gitEmail, config.Git))
+			applyGitConfig(runner, config.Git)
+		}},
+		{"SSH", false, func() {
+			if config.SSH == nil {
+				return
+			}
+			if publicKey, err := setupSSH(runner, config.SSH); err != nil {
+				fmt.Printf("Error setting up SSH: %v\n", err)
+				report.add("SSH setup failed", err.Error())
+			} else {
+				report.add("SSH public key", publicKey)
+			}
+		}},
+		{"Commit signing", false, func() {
+			if config.Git.Signing == nil {
+				return
+			}
+			if signingKey, err := setupCommitSigning(runner, config.Git.Signing, config.SSH); err != nil {
+				fmt.Printf("Error setting up commit signing: %v\n", err)
+				report.add("Commit signing failed", err.Error())
+			} else {
+				report.add("Commit signing key (add it to your git host as a signing key)", strings.Split(signingKey, "\n")...)
+			}
+		}},
+		{"Dotfiles", false, func() {
+			edited, err := installDotfiles(runner, config.DotfilesRepo, config.Dotfiles, os.Getenv("HOME"), vars)
+			if err != nil {
+				fmt.Printf("Error installing dotfiles: %v\n", err)
+				report.add("Dotfiles failed", err.Error())
+			}
+			editedDotfiles = edited
+		}},
+		{"Firewall settings", config.Security != nil && len(firewallFixes(runner, *config.Security)) > 0, func() {
+			if config.Security == nil || !config.Security.EnableFirewall {
+				return
+			}
+			if err := enableFirewall(runner, *config.Security); err != nil {
+				fmt.Printf("Error turning on the firewall: %v\n", err)
+				report.add("Firewall settings failed", err.Error())
+			}
+		}},
+		{"Security baseline", false, func() {
+			if config.Security == nil {
+				return
+			}
+			var failing []string
+			for _, result := range checkSecurity(runner, *config.Security) {
+				if !result.compliant() {
+					failing = append(failing, result.String())
+				}
+			}
+			report.add("Security baseline not met", failing...)
+		}},
+		{"Cleanup", false, cleanup},
 	}
+	skipped := runSteps(steps, elevate)
+
+	report.add("Dotfiles edited locally (not re-rendered)", editedDotfiles...)
+	report.addAppStore(appStoreResults, outdatedApps)
+	report.add("Runtime problems", runtimeFailures...)
+	report.add("Skipped steps that need sudo", skipped...)
+	report.addBrewOutdated(runner)
+	finishAndReboot(runner, report, deferredUpdates, elevate)
 
-	return &config, nil
 }
 
 func clearScreen() {
@@ -133,138 +261,6 @@ func printASCIIArt() {
 	fmt.Println("|_|_| |_|___/\\__\\__,_|_|_(_)___/_| |_|")
 	fmt.Println()
 	fmt.Println()
-	fmt.Println("Enter root password")
-}
-
-func promptForRootPassword() {
-	cmd := exec.Command("sudo", "-v")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		fmt.Printf("Error prompting for root password: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-// TODO Fix sudo keep alive
-func keepSudoAlive() {
-	go func() {
-		for {
-			cmd := exec.Command("sudo", "-n", "true")
-			err := cmd.Run()
-			if err != nil {
-				fmt.Printf("Error keeping sudo alive: %v\n", err)
-			}
-			time.Sleep(60 * time.Second)
-		}
-	}()
-}
-
-func updateMacOS() {
-	clearScreen()
-	fmt.Println("Updating macOS...")
-	cmd := exec.Command("sudo", "softwareupdate", "-i", "-a")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		fmt.Printf("Error updating macOS: %v\n", err)
-	}
-}
-
-// TODO Check for better command line options
-func installRosetta() {
-	fmt.Println("Checking if Rosetta is installed...")
-	cmd := exec.Command("arch", "-x86_64", "/usr/bin/true")
-	err := cmd.Run()
-	if err == nil {
-		fmt.Println("Rosetta is already installed.")
-		return
-	}
-
-	fmt.Println("Installing Rosetta...")
-	cmd = exec.Command("sudo", "softwareupdate", "--install-rosetta", "--agree-to-license")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Error installing Rosetta: %v\n", err)
-	}
-}
-
-func installHomebrew() {
-	clearScreen()
-	fmt.Println("Checking if Homebrew is installed...")
-	cmd := exec.Command("brew", "--version")
-	err := cmd.Run()
-	if err == nil {
-		fmt.Println("Homebrew is already installed.")
-		return
-	}
-
-	fmt.Println("Installing Homebrew...")
-	cmd = exec.Command("bash", "-c", "NONINTERACTIVE=1 /bin/bash -c \"$(curl -fsSL https://raw.githubusercontent.com/Homebrew/install/HEAD/install.sh)\"")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Error installing Homebrew: %v\n", err)
-	}
-}
-
-func setupHomebrew() {
-	clearScreen()
-	zprofilePath := os.Getenv("HOME") + "/.zprofile"
-	homebrewInit := `eval "$(/opt/homebrew/bin/brew shellenv)"`
-
-	// Check if the initialization line is already in .zprofile
-	file, err := os.Open(zprofilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Create the file if it does not exist
-			file, err = os.Create(zprofilePath)
-			if err != nil {
-				fmt.Printf("Error creating .zprofile: %v\n", err)
-				return
-			}
-		} else {
-			fmt.Printf("Error opening .zprofile: %v\n", err)
-			return
-		}
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), homebrewInit) {
-			fmt.Println("Homebrew initialization is already in .zprofile.")
-			return
-		}
-	}
-
-	// Append the initialization line to .zprofile
-	file, err = os.OpenFile(zprofilePath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Printf("Error opening .zprofile for writing: %v\n", err)
-		return
-	}
-	defer file.Close()
-
-	_, err = file.WriteString(homebrewInit + "\n")
-	if err != nil {
-		fmt.Printf("Error writing to .zprofile: %v\n", err)
-		return
-	}
-
-	// Immediately evaluate the Homebrew environment settings for the current session
-	cmd := exec.Command("bash", "-c", homebrewInit)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Error evaluating Homebrew environment settings: %v\n", err)
-	}
 }
 
 func checkAndUpdateHomebrew() {
@@ -323,91 +319,6 @@ func installCasks(casks []string) {
 	}
 }
 
-// Install App Store Apps
-func installAppStoreApps(apps []string) {
-	clearScreen()
-	fmt.Println("Checking if mas is installed...")
-	cmd := exec.Command("mas", "--version")
-	err := cmd.Run()
-	if err != nil {
-		fmt.Println("mas is not installed. Installing mas...")
-		cmd = exec.Command("brew", "install", "mas")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
-		if err != nil {
-			fmt.Printf("Error installing mas: %v\n", err)
-			return
-		}
-	} else {
-		fmt.Println("mas is already installed.")
-	}
-
-	fmt.Println("Installing Mac App Store applications...")
-	for _, app := range apps {
-		cmd := exec.Command("mas", "install", app)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			fmt.Printf("Failed to install app %s. Continuing...\n", app)
-		}
-	}
-}
-
-// Install .NET
-func installDotNet() {
-	clearScreen()
-	fmt.Println("Checking if .NET is installed...")
-	cmd := exec.Command("dotnet", "--version")
-	err := cmd.Run()
-	if err == nil {
-		fmt.Println(".NET is already installed.")
-		return
-	}
-
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Install .NET? [y/N]: ")
-	reply, _ := reader.ReadString('\n')
-	reply = strings.TrimSpace(reply)
-	if strings.ToLower(reply) == "y" {
-		cmd := exec.Command("brew", "install", "dotnet")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
-			fmt.Printf("Failed to install .NET: %v\n", err)
-			return
-		}
-
-		// Export DOTNET_ROOT to zsh
-		zprofilePath := os.Getenv("HOME") + "/.zprofile"
-		dotnetExport := `export DOTNET_ROOT="/opt/homebrew/opt/dotnet/libexec"`
-
-		file, err := os.OpenFile(zprofilePath, os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
-			fmt.Printf("Error opening .zprofile for writing: %v\n", err)
-			return
-		}
-		defer file.Close()
-
-		_, err = file.WriteString(dotnetExport + "\n")
-		if err != nil {
-			fmt.Printf("Error writing to .zprofile: %v\n", err)
-			return
-		}
-
-		// Immediately evaluate the DOTNET_ROOT environment setting for the current session
-		cmd = exec.Command("bash", "-c", dotnetExport)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err = cmd.Run()
-		if err != nil {
-			fmt.Printf("Error evaluating DOTNET_ROOT environment setting: %v\n", err)
-		}
-	}
-}
-
 func configureDefaultSettings(settings []string) {
 	clearScreen()
 	reader := bufio.NewReader(os.Stdin)
@@ -430,7 +341,7 @@ func configureDefaultSettings(settings []string) {
 	}
 }
 
-func configureDockSettings(replaceItems, addItems, removeItems []string) {
+func configureDockSettings(dock DockConfig) {
 	clearScreen()
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Apply Dock settings? [y/N]: ")
@@ -448,23 +359,18 @@ func configureDockSettings(replaceItems, addItems, removeItems []string) {
 		}
 
 		// Handle replacements
-		for _, item := range replaceItems {
-			parts := strings.Split(item, "|")
-			if len(parts) == 2 {
-				addApp := parts[0]
-				replaceApp := parts[1]
-				cmd := exec.Command("dockutil", "--add", addApp, "--replacing", replaceApp)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				err := cmd.Run()
-				if err != nil {
-					fmt.Printf("Failed to replace %s with %s: %v\n", replaceApp, addApp, err)
-				}
+		for _, item := range dock.Replace {
+			cmd := exec.Command("dockutil", "--add", item.App, "--replacing", item.Replacing)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			err := cmd.Run()
+			if err != nil {
+				fmt.Printf("Failed to replace %s with %s: %v\n", item.Replacing, item.App, err)
 			}
 		}
 
 		// Handle additions
-		for _, app := range addItems {
+		for _, app := range dock.Add {
 			cmd := exec.Command("dockutil", "--add", app)
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
@@ -475,7 +381,7 @@ func configureDockSettings(replaceItems, addItems, removeItems []string) {
 		}
 
 		// Handle removals
-		for _, app := range removeItems {
+		for _, app := range dock.Remove {
 			cmd := exec.Command("dockutil", "--remove", app)
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
@@ -529,70 +435,73 @@ func cleanup() {
 
 }
 
-func setupGitLogin() {
+// setupGitLogin makes sure git has a user.name and user.email. Each is
+// handled on its own: a value given with a flag, the environment or the
+// config is applied without asking, an existing value is kept unless the user
+// chooses to overwrite it, and a missing value is prompted for on in.
+func setupGitLogin(r Runner, in io.Reader, identity gitIdentity) {
 	clearScreen()
-	reader := bufio.NewReader(os.Stdin)
+	fmt.Println("SET UP GIT")
+	reader := bufio.NewReader(in)
 
-	// Check if Git username is already set
-	cmd := exec.Command("git", "config", "--global", "user.name")
-	existingName, err := cmd.Output()
-	if err == nil && len(existingName) > 0 {
-		fmt.Printf("Existing Git username: %s\n", strings.TrimSpace(string(existingName)))
-		fmt.Print("Do you want to overwrite it? [y/N]: ")
-		reply, _ := reader.ReadString('\n')
-		reply = strings.TrimSpace(reply)
-		if strings.ToLower(reply) != "y" {
-			fmt.Println("Keeping existing Git username.")
-			return
-		}
+	fields := []struct {
+		key, label, value string
+		valid             func(string) error
+	}{
+		{"user.name", "username", identity.Name, validGitName},
+		{"user.email", "email", identity.Email, validGitEmail},
 	}
 
-	// Check if Git email is already set
-	cmd = exec.Command("git", "config", "--global", "user.email")
-	existingEmail, err := cmd.Output()
-	if err == nil && len(existingEmail) > 0 {
-		fmt.Printf("Existing Git email: %s\n", strings.TrimSpace(string(existingEmail)))
-		fmt.Print("Do you want to overwrite it? [y/N]: ")
-		reply, _ := reader.ReadString('\n')
-		reply = strings.TrimSpace(reply)
-		if strings.ToLower(reply) != "y" {
-			fmt.Println("Keeping existing Git email.")
-			return
-		}
-	}
+	for _, field := range fields {
+		existing, _ := r.Output("git", "config", "--global", "--get", field.key)
+		existing = strings.TrimSpace(existing)
 
-	fmt.Println("SET UP GIT")
-	fmt.Print("Please enter your git username: ")
-	name, _ := reader.ReadString('\n')
-	name = strings.TrimSpace(name)
+		value := field.value
+		if value != "" {
+			if err := field.valid(value); err != nil {
+				fmt.Printf("Ignoring git %s %q: %v\n", field.label, value, err)
+				value = ""
+			}
+		}
 
-	fmt.Print("Please enter your git email: ")
-	email, _ := reader.ReadString('\n')
-	email = strings.TrimSpace(email)
+		if value == "" && existing != "" {
+			fmt.Printf("Existing Git %s: %s\n", field.label, existing)
+			fmt.Print("Do you want to overwrite it? [y/N]: ")
+			reply, _ := reader.ReadString('\n')
+			if strings.ToLower(strings.TrimSpace(reply)) != "y" {
+				fmt.Printf("Keeping existing Git %s.\n", field.label)
+				continue
+			}
+		}
 
-	cmd = exec.Command("git", "config", "--global", "user.name", name)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Failed to set git username: %v\n", err)
-		return
-	}
+		for attempt := 0; value == "" && attempt < 3; attempt++ {
+			fmt.Printf("Please enter your git %s: ", field.label)
+			reply, err := reader.ReadString('\n')
+			reply = strings.TrimSpace(reply)
+			if verr := field.valid(reply); verr == nil {
+				value = reply
+			} else if reply != "" {
+				fmt.Printf("Invalid git %s: %v\n", field.label, verr)
+			}
+			if err != nil {
+				break
+			}
+		}
+		if value == "" {
+			fmt.Printf("No git %s given, skipping.\n", field.label)
+			continue
+		}
 
-	cmd = exec.Command("git", "config", "--global", "user.email", email)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Failed to set git email: %v\n", err)
-		return
+		if value == existing {
+			fmt.Printf("Git %s is already %s.\n", field.label, value)
+			continue
+		}
+		if err := r.Run("git", "config", "--global", field.key, value); err != nil {
+			fmt.Printf("Failed to set git %s: %v\n", field.label, err)
+		}
 	}
 
-	cmd = exec.Command("git", "config", "--global", "color.ui", "true")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
+	if err := r.Run("git", "config", "--global", "color.ui", "true"); err != nil {
 		fmt.Printf("Failed to set git color.ui: %v\n", err)
 		return
 	}
@@ -600,7 +509,9 @@ func setupGitLogin() {
 	fmt.Println("Git is Setup")
 }
 
-func finishAndReboot() {
+// finishAndReboot prints the summary and offers to reboot. Rebooting needs
+// sudo, so without elevate, as with --no-sudo, it only suggests a restart.
+func finishAndReboot(r Runner, report *summaryReport, deferredUpdates []softwareUpdate, elevate func() error) {
 	clearScreen()
 	fmt.Println("______ _____ _   _  _____ ")
 	fmt.Println("|  _  \\  _  | \\ | ||  ___|")
@@ -609,22 +520,48 @@ func finishAndReboot() {
 	fmt.Println("| |/ /\\ \\_/ / |\\  || |___ ")
 	fmt.Println("|___/  \\___/\\_| \\_/\\____/ ")
 
+	var deferred []string
+	for _, update := range deferredUpdates {
+		deferred = append(deferred, update.String())
+	}
+	report.add("macOS updates waiting for a restart", deferred...)
+
 	fmt.Println()
+	report.print(os.Stdout)
 	fmt.Println()
+	if elevate == nil {
+		fmt.Println("Restart your Mac to finish the deployment.")
+		return
+	}
 	fmt.Print("Would you like to reboot now? [y/N]: ")
 	reader := bufio.NewReader(os.Stdin)
 	reply, _ := reader.ReadString('\n')
 	reply = strings.TrimSpace(reply)
 	if strings.ToLower(reply) == "y" {
-		cmd := exec.Command("sudo", "reboot")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		err := cmd.Run()
-		if err != nil {
+		if err := elevate(); err != nil {
+			fmt.Printf("Error rebooting: %v\n", err)
+			return
+		}
+		if len(deferredUpdates) > 0 {
+			fmt.Println("Installing the deferred macOS updates. The Mac restarts when they are done.")
+			if err := installDeferredUpdates(r, deferredUpdates); err == nil {
+				os.Exit(0)
+			} else {
+				fmt.Printf("Error installing macOS updates: %v\n", err)
+			}
+		}
+		if err := r.Run("sudo", "reboot"); err != nil {
 			fmt.Printf("Error rebooting: %v\n", err)
 		}
 		os.Exit(0)
 	} else {
 		fmt.Println("Reboot canceled.")
+		if len(deferredUpdates) > 0 {
+			labels := make([]string, len(deferredUpdates))
+			for i, update := range deferredUpdates {
+				labels[i] = fmt.Sprintf("%q", update.Label)
+			}
+			fmt.Printf("Install the deferred macOS updates with: sudo softwareupdate -i --restart %s\n", strings.Join(labels, " "))
+		}
 	}
 }
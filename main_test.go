@@ -2,10 +2,11 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -14,19 +15,19 @@ import (
 func TestReadConfig(t *testing.T) {
 	content := `
 casks:
-	- google-chrome
+  - google-chrome
 formulae:
-	- git
+  - git
 appStore:
-	- 1234567890
+  - 1234567890
 defaultSettings:
-	- "defaults write com.apple.finder AppleShowAllFiles YES"
+  - "defaults write com.apple.finder AppleShowAllFiles YES"
 dockReplace:
-	- "/Applications/Safari.app|/Applications/Firefox.app"
+  - "/Applications/Safari.app|/Applications/Firefox.app"
 dockAdd:
-	- "/Applications/Slack.app"
+  - "/Applications/Slack.app"
 dockRemove:
-	- "/Applications/Mail.app"
+  - "/Applications/Mail.app"
 `
 	tmpfile, err := ioutil.TempFile("", "example.*.yml")
 	if err != nil {
@@ -75,8 +76,12 @@ func TestPromptForRootPassword(t *testing.T) {
 }
 
 func TestKeepSudoAlive(t *testing.T) {
-	go keepSudoAlive()
-	time.Sleep(2 * time.Second)
+	s := newTestSudoSession(newFakeSudo(t).path)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
 }
 
 func TestUpdateMacOS(t *testing.T) {
@@ -124,47 +129,27 @@ func TestInstallHomebrew(t *testing.T) {
 }
 
 func TestSetupHomebrew(t *testing.T) {
-	zprofilePath := os.Getenv("HOME") + "/.zprofile"
-	homebrewInit := `eval "$(/opt/homebrew/bin/brew shellenv)"`
-
-	file, err := os.Open(zprofilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			file, err = os.Create(zprofilePath)
-			if err != nil {
-				t.Fatalf("Expected no error, got %v", err)
-			}
-		} else {
+	t.Setenv("PATH", "/usr/bin:/bin")
+	t.Setenv("HOMEBREW_PREFIX", "")
+	profile := filepath.Join(t.TempDir(), ".zprofile")
+
+	brew := filepath.Join(brewPrefix(), "bin", "brew")
+	r := newFakeRunner(map[string]fakeResult{brew + " shellenv": {output: brewShellenvPathHelper}})
+	for i := 0; i < 2; i++ {
+		if err := setupHomebrew(r, profile); err != nil {
 			t.Fatalf("Expected no error, got %v", err)
 		}
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), homebrewInit) {
-			t.Log("Homebrew initialization is already in .zprofile.")
-			return
-		}
+	if got := os.Getenv("HOMEBREW_PREFIX"); got != "/usr/local" {
+		t.Errorf("Expected HOMEBREW_PREFIX to be applied, got %q", got)
 	}
-
-	file, err = os.OpenFile(zprofilePath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
+	if got := os.Getenv("PATH"); got != "/usr/local/bin:/usr/local/sbin:/usr/bin:/bin" {
+		t.Errorf("Expected Homebrew on the PATH once, got %q", got)
 	}
-	defer file.Close()
-
-	_, err = file.WriteString(homebrewInit + "\n")
-	if err != nil {
-		t.Fatalf("Expected no error, got %v", err)
-	}
-
-	cmd := exec.Command("bash", "-c", homebrewInit)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
-	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
+	data := string(mustRead(t, profile))
+	if line := `eval "$(` + brew + ` shellenv)"`; strings.Count(data, line) != 1 {
+		t.Errorf("Expected the profile to contain %s once, got:\n%s", line, data)
 	}
 }
 
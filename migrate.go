@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// currentConfigVersion is the config format this build reads natively. Older
+// documents are upgraded in memory by the migrations below; configs without a
+// version key are version 1.
+const currentConfigVersion = 3
+
+// configMigration upgrades a config document from one version to the next.
+// Migrations work on the parsed node tree, so they apply to YAML, JSON and
+// TOML alike and keep the positions of the values they move.
+type configMigration struct {
+	from        int
+	description string
+	migrate     func(root *yaml.Node) []configProblem
+}
+
+var configMigrations = []configMigration{
+	{
+		from:        1,
+		description: "move dockReplace, dockAdd and dockRemove into a structured dock section",
+		migrate:     migrateDockSection,
+	},
+	{
+		from:        2,
+		description: "move the appStore list into appStore.apps",
+		migrate:     migrateAppStoreSection,
+	},
+}
+
+// configVersion returns the version a document declares.
+func configVersion(root *yaml.Node) (int, error) {
+	value := mappingValue(root, "version")
+	if value == nil || value.Tag == "!!null" {
+		return 1, nil
+	}
+
+	version, err := strconv.Atoi(value.Value)
+	if err != nil || version < 1 || value.Kind != yaml.ScalarNode {
+		return 0, fmt.Errorf("line %d: column %d: version must be a positive number, got %q", value.Line, value.Column, value.Value)
+	}
+	return version, nil
+}
+
+// migrateConfigNode upgrades root in place to currentConfigVersion. It returns
+// the deprecation warnings and errors found along the way, and the
+// descriptions of the migrations that were applied.
+func migrateConfigNode(root *yaml.Node) ([]configProblem, []string, error) {
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, nil
+	}
+
+	version, err := configVersion(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version > currentConfigVersion {
+		return nil, nil, fmt.Errorf("config version %d is newer than this gomacdeploy supports (%d); please upgrade gomacdeploy", version, currentConfigVersion)
+	}
+	if version == currentConfigVersion {
+		return nil, nil, nil
+	}
+
+	var problems []configProblem
+	var applied []string
+	for _, migration := range configMigrations {
+		if migration.from >= version {
+			problems = append(problems, migration.migrate(root)...)
+			applied = append(applied, fmt.Sprintf("v%d -> v%d: %s", migration.from, migration.from+1, migration.description))
+		}
+	}
+
+	setMappingValue(root, "version", &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   "!!int",
+		Value: strconv.Itoa(currentConfigVersion),
+	})
+
+	return problems, applied, nil
+}
+
+func newWarning(node *yaml.Node, format string, args ...interface{}) configProblem {
+	problem := newProblem(node, format, args...)
+	problem.Warning = true
+	return problem
+}
+
+// removeMappingKey deletes key from a mapping node and returns the removed
+// key and value nodes along with the index they were at.
+func removeMappingKey(node *yaml.Node, key string) (*yaml.Node, *yaml.Node, int) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			k, v := node.Content[i], node.Content[i+1]
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return k, v, i
+		}
+	}
+	return nil, nil, -1
+}
+
+// setMappingValue replaces the value stored under key, or inserts the key at
+// the top of the mapping if it is not present.
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	node.Content = append([]*yaml.Node{keyNode, value}, node.Content...)
+}
+
+// migrateDockSection turns the version 1 dock keys into the version 2 dock
+// section and the "replacement_app_path|app_name_to_replace" strings into
+// {app, replacing} entries.
+func migrateDockSection(root *yaml.Node) []configProblem {
+	legacy := []struct{ from, to string }{
+		{"dockReplace", "replace"},
+		{"dockAdd", "add"},
+		{"dockRemove", "remove"},
+	}
+
+	var problems []configProblem
+	dock := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	insertAt := -1
+
+	for _, l := range legacy {
+		key, value, index := removeMappingKey(root, l.from)
+		if key == nil {
+			continue
+		}
+		if insertAt < 0 || index < insertAt {
+			insertAt = index
+		}
+
+		problems = append(problems, newWarning(key, "%s is deprecated, use dock.%s (run \"gomacdeploy config migrate --write\" to update the file)", l.from, l.to))
+
+		if l.from == "dockReplace" && value.Kind == yaml.SequenceNode {
+			var items []*yaml.Node
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					items = append(items, item)
+					continue
+				}
+				replacement, problem := parseDockReplacement(item)
+				if problem != nil {
+					problems = append(problems, *problem)
+					continue
+				}
+				items = append(items, replacement)
+			}
+			value.Content = items
+		}
+
+		newKey := &yaml.Node{
+			Kind:        yaml.ScalarNode,
+			Tag:         "!!str",
+			Value:       l.to,
+			Line:        key.Line,
+			Column:      key.Column,
+			HeadComment: key.HeadComment,
+			LineComment: key.LineComment,
+		}
+		dock.Content = append(dock.Content, newKey, value)
+	}
+
+	if insertAt < 0 {
+		return problems
+	}
+
+	if existing := mappingValue(root, "dock"); existing != nil {
+		problems = append(problems, newProblem(existing, "dock cannot be combined with dockReplace, dockAdd or dockRemove"))
+		return problems
+	}
+
+	dockKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "dock"}
+	if len(dock.Content) > 0 {
+		dockKey.Line, dockKey.Column = dock.Content[0].Line, dock.Content[0].Column
+		dockKey.HeadComment, dock.Content[0].HeadComment = dock.Content[0].HeadComment, ""
+	}
+
+	content := append([]*yaml.Node{}, root.Content[:insertAt]...)
+	content = append(content, dockKey, dock)
+	root.Content = append(content, root.Content[insertAt:]...)
+
+	return problems
+}
+
+// parseDockReplacement converts a legacy "app|replacing" string into a
+// mapping node.
+func parseDockReplacement(item *yaml.Node) (*yaml.Node, *configProblem) {
+	parts := strings.Split(item.Value, "|")
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		problem := newProblem(item, "dockReplace entry %q must have the form \"replacement_app_path|app_name_to_replace\"", item.Value)
+		return nil, &problem
+	}
+
+	scalar := func(value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: strings.TrimSpace(value), Line: item.Line, Column: item.Column}
+	}
+
+	return &yaml.Node{
+		Kind:        yaml.MappingNode,
+		Tag:         "!!map",
+		Line:        item.Line,
+		Column:      item.Column,
+		HeadComment: item.HeadComment,
+		LineComment: item.LineComment,
+		Content: []*yaml.Node{
+			scalar("app"), scalar(parts[0]),
+			scalar("replacing"), scalar(parts[1]),
+		},
+	}, nil
+}
+
+// migrateAppStoreSection moves the version 2 appStore list into the apps key
+// of the version 3 appStore section, which also holds the upgrade policy.
+func migrateAppStoreSection(root *yaml.Node) []configProblem {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != "appStore" || value.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		root.Content[i+1] = &yaml.Node{
+			Kind:   yaml.MappingNode,
+			Tag:    "!!map",
+			Line:   value.Line,
+			Column: value.Column,
+			Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Tag: "!!str", Value: "apps", Line: value.Line, Column: value.Column},
+				value,
+			},
+		}
+		return []configProblem{newWarning(key, "appStore as a list is deprecated, use appStore.apps (run \"gomacdeploy config migrate --write\" to update the file)")}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const configV1 = `# Dock items
+dockReplace:
+  - /Applications/Google Chrome.app|Safari
+dockAdd:
+  - /Applications/Warp.app # terminal
+dockRemove:
+  - FaceTime
+casks:
+  - arc
+`
+
+func TestDecodeConfigMigratesV1(t *testing.T) {
+	config, err := decodeConfig([]byte(configV1), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	want := &Config{
+		Version: currentConfigVersion,
+		Casks:   []string{"arc"},
+		Dock: DockConfig{
+			Replace: []DockReplacement{{App: "/Applications/Google Chrome.app", Replacing: "Safari"}},
+			Add:     []string{"/Applications/Warp.app"},
+			Remove:  []string{"FaceTime"},
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("got %+v, want %+v", config, want)
+	}
+}
+
+func TestDecodeConfigMigratesV1JSON(t *testing.T) {
+	config, err := decodeConfig([]byte(`{"dockReplace": ["/Applications/Arc.app|Safari"]}`), formatJSON)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(config.Dock.Replace) != 1 || config.Dock.Replace[0].Replacing != "Safari" {
+		t.Errorf("Unexpected dock %+v", config.Dock)
+	}
+}
+
+func TestMigrateConfigNodeWarnings(t *testing.T) {
+	root, problems, applied, err := parseAndMigrateConfig([]byte(configV1), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(applied) != len(configMigrations) {
+		t.Errorf("Expected every migration to apply, got %v", applied)
+	}
+
+	if len(problems) != 3 {
+		t.Fatalf("Expected 3 deprecation warnings, got %v", problems)
+	}
+	for _, problem := range problems {
+		if !problem.Warning || !strings.Contains(problem.Message, "deprecated") {
+			t.Errorf("Expected deprecation warning, got %v", problem)
+		}
+	}
+	if problems[0].Line != 2 {
+		t.Errorf("Expected warning at the dockReplace key, got %v", problems[0])
+	}
+
+	if version, _ := configVersion(root); version != currentConfigVersion {
+		t.Errorf("Expected version %d after migration, got %d", currentConfigVersion, version)
+	}
+}
+
+func TestDecodeConfigMigratesV2AppStore(t *testing.T) {
+	content := "version: 2\nappStore:\n  - 409201541 # Pages\n  - Xcode\n"
+	root, problems, applied, err := parseAndMigrateConfig([]byte(content), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("Expected one migration, got %v", applied)
+	}
+	if len(problems) != 1 || !problems[0].Warning || problems[0].Line != 2 {
+		t.Errorf("Expected a deprecation warning at the appStore key, got %v", problems)
+	}
+
+	var config Config
+	if err := root.Decode(&config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := []AppStoreApp{{ID: "409201541"}, {Name: "Xcode"}}
+	if !reflect.DeepEqual(config.AppStore.Apps, want) {
+		t.Errorf("got %+v, want %+v", config.AppStore.Apps, want)
+	}
+}
+
+func TestMigrateConfigNodeErrors(t *testing.T) {
+	_, err := decodeConfig([]byte("dockReplace:\n  - /Applications/Arc.app\n"), formatYAML)
+	if err == nil || !strings.Contains(err.Error(), "2:5: dockReplace entry") {
+		t.Errorf("Expected malformed dockReplace error, got %v", err)
+	}
+
+	_, err = decodeConfig([]byte("version: 99\n"), formatYAML)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected unsupported version error, got %v", err)
+	}
+
+	_, err = decodeConfig([]byte("version: latest\n"), formatYAML)
+	if err == nil {
+		t.Error("Expected invalid version error, got nil")
+	}
+
+	_, err = decodeConfig([]byte("version: 2\ndockAdd:\n  - /Applications/Arc.app\n"), formatYAML)
+	if err == nil || !strings.Contains(err.Error(), `unknown key "dockAdd"`) {
+		t.Errorf("Expected legacy keys to be rejected in a version 2 config, got %v", err)
+	}
+}
+
+func TestMigrateCommandWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deploy.yml")
+	if err := os.WriteFile(path, []byte(configV1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := migrateCommand([]string{"--write", path}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"version: 3", "# Dock items", "# terminal", "replacing: Safari"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected migrated file to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	problems := validateConfig(data, formatYAML)
+	if len(problems) != 0 {
+		t.Errorf("Expected migrated file to validate cleanly, got %v", problems)
+	}
+
+	if code := migrateCommand([]string{"--write", path}); code != 0 {
+		t.Errorf("Expected migrating a current config to succeed, got %d", code)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeManagedBlock writes lines into the file at path between marker
+// comments naming the block. An existing block with the same name is replaced
+// in place, so running it again with the same lines leaves the file as it
+// was. Everything outside the block is kept. It reports whether the file
+// changed.
+func writeManagedBlock(path, name string, lines []string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	updated := replaceManagedBlock(string(data), name, lines)
+	if updated == string(data) {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(path, []byte(updated), 0644)
+}
+
+// replaceManagedBlock returns content with the named block set to lines. No
+// lines removes the block.
+func replaceManagedBlock(content, name string, lines []string) string {
+	begin := fmt.Sprintf("# >>> gomacdeploy %s >>>", name)
+	end := fmt.Sprintf("# <<< gomacdeploy %s <<<", name)
+
+	var block string
+	if len(lines) > 0 {
+		block = begin + "\n" + strings.Join(lines, "\n") + "\n" + end + "\n"
+	}
+
+	start := strings.Index(content, begin+"\n")
+	stop := strings.Index(content, end+"\n")
+	switch {
+	case start >= 0 && stop > start:
+		return content[:start] + block + content[stop+len(end)+1:]
+	case block == "":
+		return content
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + block
+}
+
+// zprofilePath returns the login shell profile environment lines are
+// written to.
+func zprofilePath() string {
+	return filepath.Join(os.Getenv("HOME"), ".zprofile")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteManagedBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".zprofile")
+	if err := os.WriteFile(path, []byte("export EDITOR=vim"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := writeManagedBlock(path, "runtimes", []string{"one", "two"})
+	if err != nil || !changed {
+		t.Fatalf("Expected the block to be written, got %v, %v", changed, err)
+	}
+	if err := os.WriteFile(path, append(mustRead(t, path), "alias ll='ls -l'\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err = writeManagedBlock(path, "runtimes", []string{"one", "two"})
+	if err != nil || changed {
+		t.Errorf("Expected writing the same block to change nothing, got %v, %v", changed, err)
+	}
+
+	if _, err := writeManagedBlock(path, "runtimes", []string{"three"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	want := "export EDITOR=vim\n# >>> gomacdeploy runtimes >>>\nthree\n# <<< gomacdeploy runtimes <<<\nalias ll='ls -l'\n"
+	if got := string(mustRead(t, path)); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := writeManagedBlock(path, "runtimes", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := string(mustRead(t, path)); got != "export EDITOR=vim\nalias ll='ls -l'\n" {
+		t.Errorf("Expected an empty block to be removed, got:\n%s", got)
+	}
+}
+
+func TestWriteManagedBlockNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", ".zprofile")
+	changed, err := writeManagedBlock(path, "runtimes", nil)
+	if err != nil || changed {
+		t.Errorf("Expected nothing to be written, got %v, %v", changed, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be created, got %v", err)
+	}
+
+	if _, err := writeManagedBlock(path, "runtimes", []string{"one"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := string(mustRead(t, path)); got != "# >>> gomacdeploy runtimes >>>\none\n# <<< gomacdeploy runtimes <<<\n" {
+		t.Errorf("Unexpected file:\n%s", got)
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// configPin describes how a remotely hosted config must be verified before it
+// is used. Both fields are optional; when set, a config that does not match is
+// rejected and never written to the cache.
+type configPin struct {
+	SHA256    string // hex encoded sha256 digest of the config file
+	PublicKey string // base64 encoded ed25519 key used to check <config>.sig
+}
+
+// isRemoteConfig reports whether location refers to a config that has to be
+// downloaded rather than read from disk. Only transports that authenticate
+// the server count: https, and git over gitTransports, or the default ones
+// if it is nil.
+func isRemoteConfig(location string, gitTransports []string) bool {
+	repo, ok := strings.CutPrefix(location, "git+")
+	if !ok {
+		return strings.HasPrefix(location, "https://")
+	}
+	if gitTransports == nil {
+		gitTransports = defaultGitTransports
+	}
+	for _, prefix := range gitTransports {
+		if strings.HasPrefix(repo, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultGitTransports are the URL prefixes a git config location may use:
+// https, ssh and the scp-like git@host:path form.
+var defaultGitTransports = []string{"https://", "ssh://", "git@"}
+
+// isInsecureConfig reports whether location asks for a download over a
+// transport isRemoteConfig does not accept, such as http or git+http.
+func isInsecureConfig(location string, gitTransports []string) bool {
+	return !isRemoteConfig(location, gitTransports) && (strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "git+"))
+}
+
+// defaultConfigCacheDir returns the directory downloaded configs are cached in.
+func defaultConfigCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "gomacdeploy", "config")
+}
+
+// resolveConfig turns the --config argument into a local file path. Local
+// paths are returned unchanged. Remote configs are downloaded, verified
+// against pin and cached, so a later run can still start if the server is
+// unreachable. gitTransports limits the URLs of git locations; nil means
+// defaultGitTransports.
+func resolveConfig(client *http.Client, location, cacheDir string, pin configPin, gitTransports []string) (string, error) {
+	if isInsecureConfig(location, gitTransports) {
+		return "", fmt.Errorf("rejecting config %s: remote configs must use https, git+https, git+ssh or git+git@", location)
+	}
+	if !isRemoteConfig(location, gitTransports) {
+		return location, nil
+	}
+
+	cached := cachedConfigPath(cacheDir, location)
+
+	data, sig, err := downloadConfig(client, location, pin.PublicKey != "")
+	if err != nil {
+		fmt.Printf("Error downloading config %s: %v\n", location, err)
+		return useCachedConfig(cached, pin)
+	}
+
+	if err := verifyConfig(data, sig, pin); err != nil {
+		return "", fmt.Errorf("rejecting config %s: %w", location, err)
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(cached, data, 0644); err != nil {
+		return "", err
+	}
+	if sig != nil {
+		if err := os.WriteFile(cached+".sig", sig, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return cached, nil
+}
+
+// useCachedConfig falls back to a previously downloaded config. The cached
+// copy is verified again so a modified cache is not trusted either.
+func useCachedConfig(cached string, pin configPin) (string, error) {
+	data, err := os.ReadFile(cached)
+	if err != nil {
+		return "", fmt.Errorf("no cached copy available: %w", err)
+	}
+
+	var sig []byte
+	if pin.PublicKey != "" {
+		sig, err = os.ReadFile(cached + ".sig")
+		if err != nil {
+			return "", fmt.Errorf("cached config has no signature: %w", err)
+		}
+	}
+
+	if err := verifyConfig(data, sig, pin); err != nil {
+		return "", fmt.Errorf("rejecting cached config: %w", err)
+	}
+
+	fmt.Printf("Using cached config %s\n", cached)
+	return cached, nil
+}
+
+// cachedConfigPath derives a stable cache file name from the config location.
+func cachedConfigPath(cacheDir, location string) string {
+	sum := sha256.Sum256([]byte(location))
+	name := hex.EncodeToString(sum[:8])
+
+	file := strings.SplitN(location, "?", 2)[0]
+	if strings.HasPrefix(location, "git+") {
+		if _, path, _, err := parseGitConfigLocation(location); err == nil {
+			file = path
+		}
+	}
+
+	ext := filepath.Ext(file)
+	if ext == "" || strings.ContainsAny(ext, "/#") {
+		ext = ".yml"
+	}
+	return filepath.Join(cacheDir, name+ext)
+}
+
+// downloadConfig fetches the config and, when wantSig is set, its detached
+// signature.
+func downloadConfig(client *http.Client, location string, wantSig bool) ([]byte, []byte, error) {
+	if strings.HasPrefix(location, "git+") {
+		return downloadGitConfig(location, wantSig)
+	}
+
+	data, err := httpGet(client, location)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !wantSig {
+		return data, nil, nil
+	}
+
+	sig, err := httpGet(client, location+".sig")
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching signature: %w", err)
+	}
+	return data, sig, nil
+}
+
+func httpGet(client *http.Client, url string) ([]byte, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseGitConfigLocation splits a git+<url>#<path>@<ref> location. The ref is
+// optional and defaults to HEAD.
+func parseGitConfigLocation(location string) (repo, path, ref string, err error) {
+	rest := strings.TrimPrefix(location, "git+")
+	repo, path, found := strings.Cut(rest, "#")
+	if !found || path == "" {
+		return "", "", "", fmt.Errorf("git config location %q must be git+<url>#<path>[@<ref>]", location)
+	}
+
+	ref = "HEAD"
+	if i := strings.LastIndex(path, "@"); i >= 0 {
+		path, ref = path[:i], path[i+1:]
+	}
+	if path == "" || ref == "" {
+		return "", "", "", fmt.Errorf("git config location %q must be git+<url>#<path>[@<ref>]", location)
+	}
+	return repo, path, ref, nil
+}
+
+// downloadGitConfig fetches a single commit of the repository into a scratch
+// directory and reads the config (and its signature) out of it.
+func downloadGitConfig(location string, wantSig bool) ([]byte, []byte, error) {
+	repo, path, ref, err := parseGitConfigLocation(location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "gomacdeploy-config-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		return nil, nil, fmt.Errorf("git init: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("git", "-C", dir, "fetch", "-q", "--depth", "1", repo, ref).CombinedOutput(); err != nil {
+		return nil, nil, fmt.Errorf("git fetch %s %s: %v: %s", repo, ref, err, strings.TrimSpace(string(out)))
+	}
+
+	data, err := exec.Command("git", "-C", dir, "show", "FETCH_HEAD:"+path).Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s at %s: %v", path, ref, err)
+	}
+	if !wantSig {
+		return data, nil, nil
+	}
+
+	sig, err := exec.Command("git", "-C", dir, "show", "FETCH_HEAD:"+path+".sig").Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s.sig at %s: %v", path, ref, err)
+	}
+	return data, sig, nil
+}
+
+// verifyConfig checks data against the sha256 pin and the ed25519 signature.
+func verifyConfig(data, sig []byte, pin configPin) error {
+	if pin.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		got := hex.EncodeToString(sum[:])
+		if !strings.EqualFold(got, strings.TrimSpace(pin.SHA256)) {
+			return fmt.Errorf("sha256 mismatch: got %s, want %s", got, pin.SHA256)
+		}
+	}
+
+	if pin.PublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(pin.PublicKey))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ed25519 public key")
+		}
+
+		if len(sig) != ed25519.SignatureSize {
+			sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+			if err != nil {
+				return fmt.Errorf("invalid signature encoding: %v", err)
+			}
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+			return fmt.Errorf("signature verification failed")
+		}
+	}
+
+	return nil
+}
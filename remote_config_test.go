@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const remoteConfigContent = "formulae:\n  - git\n"
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func newConfigServer(t *testing.T, files map[string]string) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolveConfigLocalPath(t *testing.T) {
+	path, err := resolveConfig(nil, "deploy_config.yml", t.TempDir(), configPin{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if path != "deploy_config.yml" {
+		t.Errorf("Expected local path to be unchanged, got %s", path)
+	}
+}
+
+func TestResolveConfigRejectsInsecureTransport(t *testing.T) {
+	for _, location := range []string{
+		"http://example.com/it/deploy_config.yml",
+		"git+http://example.com/it/config.git#deploy.yml",
+		"git+file:///srv/it/config.git#deploy.yml",
+	} {
+		if isRemoteConfig(location, nil) {
+			t.Errorf("%s: expected not to be accepted as a remote config", location)
+		}
+		if _, err := resolveConfig(nil, location, t.TempDir(), configPin{}, nil); err == nil {
+			t.Errorf("%s: expected error, got nil", location)
+		}
+	}
+}
+
+func TestResolveConfigHTTPSWithPin(t *testing.T) {
+	server := newConfigServer(t, map[string]string{"/deploy.yml": remoteConfigContent})
+	cacheDir := t.TempDir()
+
+	path, err := resolveConfig(server.Client(), server.URL+"/deploy.yml", cacheDir, configPin{SHA256: sha256Hex(remoteConfigContent)}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != remoteConfigContent {
+		t.Errorf("Expected cached config to match download, got %q", data)
+	}
+
+	config, err := readConfig(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(config.Formulae) != 1 || config.Formulae[0] != "git" {
+		t.Errorf("Expected git, got %v", config.Formulae)
+	}
+}
+
+func TestResolveConfigHTTPSRejectsTamperedConfig(t *testing.T) {
+	server := newConfigServer(t, map[string]string{"/deploy.yml": remoteConfigContent + "  - evil\n"})
+	cacheDir := t.TempDir()
+
+	_, err := resolveConfig(server.Client(), server.URL+"/deploy.yml", cacheDir, configPin{SHA256: sha256Hex(remoteConfigContent)}, nil)
+	if err == nil {
+		t.Fatal("Expected sha256 mismatch error, got nil")
+	}
+
+	entries, _ := os.ReadDir(cacheDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected rejected config not to be cached, found %d entries", len(entries))
+	}
+}
+
+func TestResolveConfigHTTPSSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(remoteConfigContent)))
+	pin := configPin{PublicKey: base64.StdEncoding.EncodeToString(pub)}
+
+	server := newConfigServer(t, map[string]string{
+		"/good.yml":     remoteConfigContent,
+		"/good.yml.sig": sig,
+		"/bad.yml":      remoteConfigContent + "  - evil\n",
+		"/bad.yml.sig":  sig,
+	})
+
+	if _, err := resolveConfig(server.Client(), server.URL+"/good.yml", t.TempDir(), pin, nil); err != nil {
+		t.Errorf("Expected valid signature to be accepted, got %v", err)
+	}
+	if _, err := resolveConfig(server.Client(), server.URL+"/bad.yml", t.TempDir(), pin, nil); err == nil {
+		t.Error("Expected invalid signature to be rejected, got nil")
+	}
+}
+
+func TestResolveConfigFallsBackToCache(t *testing.T) {
+	server := newConfigServer(t, map[string]string{"/deploy.yml": remoteConfigContent})
+	client := server.Client()
+	location := server.URL + "/deploy.yml"
+	cacheDir := t.TempDir()
+	pin := configPin{SHA256: sha256Hex(remoteConfigContent)}
+
+	first, err := resolveConfig(client, location, cacheDir, pin, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	server.Close()
+
+	second, err := resolveConfig(client, location, cacheDir, pin, nil)
+	if err != nil {
+		t.Fatalf("Expected cached config to be used, got %v", err)
+	}
+	if first != second {
+		t.Errorf("Expected cached path %s, got %s", first, second)
+	}
+
+	if err := os.WriteFile(first, []byte("casks:\n  - evil\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveConfig(client, location, cacheDir, pin, nil); err == nil {
+		t.Error("Expected modified cache to be rejected, got nil")
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_GLOBAL=/dev/null")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+	return string(out)
+}
+
+// newBareConfigRepo creates a bare repository holding files on branch main
+// and returns its file:// URL.
+func newBareConfigRepo(t *testing.T, files map[string]string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	bare := filepath.Join(root, "config.git")
+	work := filepath.Join(root, "work")
+
+	runGit(t, root, "init", "-q", "--bare", bare)
+	runGit(t, root, "init", "-q", work)
+	for name, content := range files {
+		path := filepath.Join(work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "config")
+	runGit(t, work, "push", "-q", bare, "HEAD:refs/heads/main")
+
+	return "file://" + bare
+}
+
+func TestResolveConfigGit(t *testing.T) {
+	repo := newBareConfigRepo(t, map[string]string{"configs/deploy.yml": remoteConfigContent})
+	// The test repository is a local file:// URL, which is not allowed by
+	// default.
+	transports := []string{repo}
+	location := "git+" + repo + "#configs/deploy.yml@main"
+
+	path, err := resolveConfig(nil, location, t.TempDir(), configPin{SHA256: sha256Hex(remoteConfigContent)}, transports)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != remoteConfigContent {
+		t.Errorf("Expected config from git, got %q", data)
+	}
+
+	if _, err := resolveConfig(nil, location, t.TempDir(), configPin{SHA256: sha256Hex("other")}, transports); err == nil {
+		t.Error("Expected sha256 mismatch error, got nil")
+	}
+	if _, err := resolveConfig(nil, location, t.TempDir(), configPin{SHA256: sha256Hex(remoteConfigContent)}, nil); err == nil {
+		t.Error("Expected the file:// repository to be rejected by default, got nil")
+	}
+}
+
+func TestParseGitConfigLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		repo     string
+		path     string
+		ref      string
+		wantErr  bool
+	}{
+		{"git+https://example.com/it/config.git#deploy.yml@v1.2", "https://example.com/it/config.git", "deploy.yml", "v1.2", false},
+		{"git+https://example.com/it/config.git#macs/deploy.yml", "https://example.com/it/config.git", "macs/deploy.yml", "HEAD", false},
+		{"git+ssh://git@example.com/it/config.git#deploy.yml@main", "ssh://git@example.com/it/config.git", "deploy.yml", "main", false},
+		{"git+git@example.com:it/config.git#deploy.yml", "git@example.com:it/config.git", "deploy.yml", "HEAD", false},
+		{"git+https://example.com/it/config.git", "", "", "", true},
+		{"git+https://example.com/it/config.git#deploy.yml@", "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		repo, path, ref, err := parseGitConfigLocation(tt.location)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.location, tt.wantErr, err)
+			continue
+		}
+		if repo != tt.repo || path != tt.path || ref != tt.ref {
+			t.Errorf("%s: got (%s, %s, %s), want (%s, %s, %s)", tt.location, repo, path, ref, tt.repo, tt.path, tt.ref)
+		}
+	}
+}
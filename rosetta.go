@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// rosettaPath is the Rosetta runtime, present once Rosetta is installed.
+var rosettaPath = "/Library/Apple/usr/share/rosetta/rosetta"
+
+// rosettaPackage is the receipt softwareupdate leaves after installing
+// Rosetta.
+const rosettaPackage = "com.apple.pkg.RosettaUpdateAuto"
+
+// Rosetta step outcomes.
+const (
+	rosettaInstalled      = "installed"
+	rosettaAlreadyPresent = "already present"
+	rosettaSkipped        = "skipped"
+	rosettaFailed         = "failed"
+)
+
+// rosettaResult records what the Rosetta step did and why.
+type rosettaResult struct {
+	Status string
+	Detail string
+}
+
+func (r rosettaResult) String() string {
+	if r.Detail != "" {
+		return fmt.Sprintf("Rosetta: %s (%s)", r.Status, r.Detail)
+	}
+	return "Rosetta: " + r.Status
+}
+
+// appleSilicon reports whether the Mac has an Apple silicon CPU. It asks the
+// kernel rather than checking runtime.GOARCH, which is amd64 when gomacdeploy
+// itself runs under Rosetta.
+func appleSilicon(r Runner) bool {
+	out, err := r.Output("sysctl", "-n", "hw.optional.arm64")
+	return err == nil && strings.TrimSpace(out) == "1"
+}
+
+// rosettaPresent reports whether Rosetta is installed, from the runtime on
+// disk or the package receipt.
+func rosettaPresent(r Runner) bool {
+	if _, err := os.Stat(rosettaPath); err == nil {
+		return true
+	}
+	_, err := r.Output("pkgutil", "--pkg-info", rosettaPackage)
+	return err == nil
+}
+
+// rosettaNeeded reports whether installRosetta would install Rosetta, which
+// needs sudo.
+func rosettaNeeded(r Runner, enabled *bool) bool {
+	return (enabled == nil || *enabled) && appleSilicon(r) && !rosettaPresent(r)
+}
+
+// installRosetta installs Rosetta 2 on Apple silicon Macs. Intel Macs, and
+// configs that set rosetta: false, skip the step.
+func installRosetta(r Runner, enabled *bool) rosettaResult {
+	fmt.Println("Checking if Rosetta is needed...")
+	var result rosettaResult
+	switch {
+	case enabled != nil && !*enabled:
+		result = rosettaResult{Status: rosettaSkipped, Detail: "disabled in the config"}
+	case !appleSilicon(r):
+		result = rosettaResult{Status: rosettaSkipped, Detail: "not an Apple silicon Mac"}
+	case rosettaPresent(r):
+		result = rosettaResult{Status: rosettaAlreadyPresent}
+	default:
+		fmt.Println("Installing Rosetta...")
+		if err := r.Run("sudo", "softwareupdate", "--install-rosetta", "--agree-to-license"); err != nil {
+			result = rosettaResult{Status: rosettaFailed, Detail: err.Error()}
+		} else if !rosettaPresent(r) {
+			result = rosettaResult{Status: rosettaFailed, Detail: "not found after installing"}
+		} else {
+			result = rosettaResult{Status: rosettaInstalled}
+		}
+	}
+
+	fmt.Println(result)
+	return result
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useRosettaPath points rosettaPath into a temporary directory for the test.
+func useRosettaPath(t *testing.T) {
+	old := rosettaPath
+	rosettaPath = filepath.Join(t.TempDir(), "rosetta")
+	t.Cleanup(func() { rosettaPath = old })
+}
+
+func TestInstallRosettaStatuses(t *testing.T) {
+	useRosettaPath(t)
+	off := false
+	notInstalled := fakeResult{err: errors.New("No receipt for 'com.apple.pkg.RosettaUpdateAuto' found")}
+
+	tests := []struct {
+		name    string
+		enabled *bool
+		results map[string]fakeResult
+		want    string
+	}{
+		{"disabled", &off, nil, "Rosetta: skipped (disabled in the config)"},
+		{"intel", nil, map[string]fakeResult{"sysctl -n hw.optional.arm64": {err: errors.New("unknown oid")}}, "Rosetta: skipped (not an Apple silicon Mac)"},
+		{"receipt", nil, map[string]fakeResult{"sysctl -n hw.optional.arm64": {output: "1\n"}}, "Rosetta: already present"},
+		{"verify fails", nil, map[string]fakeResult{
+			"sysctl -n hw.optional.arm64":                        {output: "1\n"},
+			"pkgutil --pkg-info com.apple.pkg.RosettaUpdateAuto": notInstalled,
+		}, "Rosetta: failed (not found after installing)"},
+		{"install fails", nil, map[string]fakeResult{
+			"sysctl -n hw.optional.arm64":                              {output: "1\n"},
+			"pkgutil --pkg-info com.apple.pkg.RosettaUpdateAuto":       notInstalled,
+			"sudo softwareupdate --install-rosetta --agree-to-license": {err: errors.New("exit status 1")},
+		}, "Rosetta: failed (exit status 1)"},
+	}
+	for _, test := range tests {
+		r := newFakeRunner(test.results)
+		if got := installRosetta(r, test.enabled).String(); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+		if test.name == "disabled" && len(r.calls) != 0 {
+			t.Errorf("Expected a disabled step to run nothing, got %v", r.calls)
+		}
+	}
+}
+
+func TestInstallRosettaInstalls(t *testing.T) {
+	useRosettaPath(t)
+
+	// The runtime appears on disk once softwareupdate has run.
+	r := &installingRunner{fakeRunner: newFakeRunner(map[string]fakeResult{
+		"sysctl -n hw.optional.arm64":                        {output: "1\n"},
+		"pkgutil --pkg-info com.apple.pkg.RosettaUpdateAuto": {err: errors.New("no receipt")},
+	})}
+	if result := installRosetta(r, nil); result.Status != rosettaInstalled {
+		t.Errorf("Expected Rosetta to be installed, got %v", result)
+	}
+}
+
+// installingRunner creates the Rosetta runtime when the installer runs.
+type installingRunner struct {
+	*fakeRunner
+}
+
+func (i *installingRunner) Run(name string, args ...string) error {
+	if err := i.fakeRunner.Run(name, args...); err != nil {
+		return err
+	}
+	return os.WriteFile(rosettaPath, nil, 0755)
+}
+
+func TestValidateRosetta(t *testing.T) {
+	if problems := validateConfig([]byte("version: 3\nrosetta: false\n"), formatYAML); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+	if problems := validateConfig([]byte("version: 3\nrosetta: sometimes\n"), formatYAML); len(problems) != 1 {
+		t.Errorf("Expected a type problem, got %v", problems)
+	}
+}
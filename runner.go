@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs external commands. Steps take a Runner rather than calling
+// exec.Command directly so they can be tested without touching the system.
+type Runner interface {
+	// Run runs the command with its output connected to the terminal.
+	Run(name string, args ...string) error
+	// Output runs the command and returns its standard output. If the command
+	// fails, the returned error includes what it wrote to standard error.
+	Output(name string, args ...string) (string, error)
+}
+
+// execRunner is the Runner used for real deployments.
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (execRunner) Output(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeResult is the canned response of a fakeRunner command.
+type fakeResult struct {
+	output string
+	err    error
+}
+
+// fakeRunner is a Runner for tests. Commands are matched on their full
+// command line; commands without a canned result succeed with no output.
+type fakeRunner struct {
+	results map[string]fakeResult
+	calls   []string
+}
+
+func newFakeRunner(results map[string]fakeResult) *fakeRunner {
+	if results == nil {
+		results = map[string]fakeResult{}
+	}
+	return &fakeRunner{results: results}
+}
+
+func (f *fakeRunner) result(name string, args ...string) fakeResult {
+	line := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, line)
+	return f.results[line]
+}
+
+func (f *fakeRunner) Run(name string, args ...string) error {
+	return f.result(name, args...).err
+}
+
+func (f *fakeRunner) Output(name string, args ...string) (string, error) {
+	r := f.result(name, args...)
+	return r.output, r.err
+}
+
+// called reports whether the command line was run.
+func (f *fakeRunner) called(line string) bool {
+	for _, call := range f.calls {
+		if call == line {
+			return true
+		}
+	}
+	return false
+}
+
+// sequenceRunner returns successive outputs for repeated commands.
+type sequenceRunner struct {
+	*fakeRunner
+	outputs map[string][]string
+}
+
+func (s *sequenceRunner) Output(name string, args ...string) (string, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	if outputs := s.outputs[line]; len(outputs) > 0 {
+		s.calls = append(s.calls, line)
+		s.outputs[line] = outputs[1:]
+		return outputs[0], nil
+	}
+	return s.fakeRunner.Output(name, args...)
+}
+
+func TestExecRunnerOutput(t *testing.T) {
+	out, err := execRunner{}.Output("sh", "-c", "echo hello")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if out != "hello\n" {
+		t.Errorf("Expected hello, got %q", out)
+	}
+
+	_, err = execRunner{}.Output("sh", "-c", "echo broken >&2; exit 3")
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected error to include stderr, got %v", err)
+	}
+}
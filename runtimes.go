@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuntimeConfig installs versions of a language runtime with a version
+// manager. Env replaces the shell lines the manager's provider emits by
+// default.
+type RuntimeConfig struct {
+	Manager  string   `yaml:"manager" json:"manager" toml:"manager"`
+	Versions []string `yaml:"versions,omitempty" json:"versions,omitempty" toml:"versions,omitempty"`
+	Default  string   `yaml:"default,omitempty" json:"default,omitempty" toml:"default,omitempty"`
+	Env      []string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty"`
+}
+
+// runtimeProvider knows how to drive one version manager. The hooks are
+// shared by every manager: check reports whether a version is installed,
+// install installs it and setDefault makes it the global default. A provider
+// without setDefault cannot select a default version. Command is the program
+// used to tell whether the manager is on the PATH; formula is the Homebrew
+// formula that installs it. Env returns the shell lines for a config, and
+// shellenv, if set, the variables they set so that they can be applied to
+// gomacdeploy's own environment as well.
+type runtimeProvider struct {
+	language   string
+	formula    string
+	command    string
+	check      func(r Runner, version string) bool
+	install    func(r Runner, version string) error
+	setDefault func(r Runner, version string) error
+	env        func(rt RuntimeConfig) []string
+	shellenv   func(r Runner, rt RuntimeConfig) ([]shellExport, error)
+}
+
+// runtimeProviders holds the supported managers, keyed by the name used in
+// the config.
+var runtimeProviders = map[string]runtimeProvider{
+	"goenv": envManagerProvider("Go", "goenv", "GOENV_ROOT", "$HOME/.goenv"),
+	"pyenv": envManagerProvider("Python", "pyenv", "PYENV_ROOT", "$HOME/.pyenv"),
+	"nvm": {
+		language: "Node.js",
+		formula:  "nvm",
+		check: func(r Runner, version string) bool {
+			return nvm(r, "which", version) == nil
+		},
+		install: func(r Runner, version string) error {
+			return nvm(r, "install", version)
+		},
+		setDefault: func(r Runner, version string) error {
+			return nvm(r, "alias", "default", version)
+		},
+		env: staticEnv(
+			`export NVM_DIR="$HOME/.nvm"`,
+			`[ -s "$(brew --prefix nvm)/nvm.sh" ] && . "$(brew --prefix nvm)/nvm.sh"`,
+		),
+	},
+	"fnm": {
+		language: "Node.js",
+		formula:  "fnm",
+		command:  "fnm",
+		check: func(r Runner, version string) bool {
+			return listedVersion(r, "v"+strings.TrimPrefix(version, "v"), "fnm", "list")
+		},
+		install: func(r Runner, version string) error {
+			return r.Run("fnm", "install", version)
+		},
+		setDefault: func(r Runner, version string) error {
+			return r.Run("fnm", "default", version)
+		},
+		env: staticEnv(`eval "$(fnm env --use-on-cd)"`),
+	},
+	"rustup": {
+		language: "Rust",
+		formula:  "rustup",
+		command:  "rustup",
+		check: func(r Runner, version string) bool {
+			return listedVersion(r, version, "rustup", "toolchain", "list")
+		},
+		install: func(r Runner, version string) error {
+			return r.Run("rustup", "toolchain", "install", version)
+		},
+		setDefault: func(r Runner, version string) error {
+			return r.Run("rustup", "default", version)
+		},
+		env: staticEnv(
+			`export PATH="$(brew --prefix rustup)/bin:$PATH"`,
+			`export PATH="$HOME/.cargo/bin:$PATH"`,
+		),
+	},
+	"jenv": {
+		language: "Java",
+		formula:  "jenv",
+		command:  "jenv",
+		check: func(r Runner, version string) bool {
+			return listedVersion(r, version, "jenv", "versions", "--bare")
+		},
+		// jenv only switches between JDKs, so the JDK itself comes from the
+		// Temurin cask and is then registered with jenv.
+		install: func(r Runner, version string) error {
+			if err := r.Run("brew", "install", "--cask", "temurin@"+version); err != nil {
+				return err
+			}
+			return r.Run("jenv", "add", fmt.Sprintf("/Library/Java/JavaVirtualMachines/temurin-%s.jdk/Contents/Home", version))
+		},
+		setDefault: func(r Runner, version string) error {
+			return r.Run("jenv", "global", version)
+		},
+		env: staticEnv(
+			`export PATH="$HOME/.jenv/bin:$PATH"`,
+			`eval "$(jenv init -)"`,
+		),
+	},
+	// .NET has no version manager; versions are Homebrew's dotnet@<major>
+	// formulae and the SDK picks the newest one unless global.json says
+	// otherwise.
+	"dotnet": {
+		language: ".NET",
+		formula:  "dotnet",
+		command:  "dotnet",
+		check: func(r Runner, version string) bool {
+			return listedVersion(r, version, "dotnet", "--list-sdks")
+		},
+		install: func(r Runner, version string) error {
+			return r.Run("brew", "install", "dotnet@"+version)
+		},
+		env: func(rt RuntimeConfig) []string {
+			return []string{fmt.Sprintf(`export DOTNET_ROOT="$(brew --prefix %s)/libexec"`, dotnetFormula(rt))}
+		},
+		shellenv: func(r Runner, rt RuntimeConfig) ([]shellExport, error) {
+			prefix, err := r.Output("brew", "--prefix", dotnetFormula(rt))
+			if err != nil {
+				return nil, err
+			}
+			return []shellExport{{Name: "DOTNET_ROOT", Value: strings.TrimSpace(prefix) + "/libexec"}}, nil
+		},
+	},
+}
+
+// staticEnv returns an env hook with the same lines for every config.
+func staticEnv(lines ...string) func(RuntimeConfig) []string {
+	return func(RuntimeConfig) []string { return lines }
+}
+
+// dotnetFormula returns the formula DOTNET_ROOT points into: dotnet@<major>
+// for the newest pinned version, matching the SDK the dotnet host picks, or
+// dotnet when no version is pinned.
+func dotnetFormula(rt RuntimeConfig) string {
+	newest := ""
+	for _, version := range rt.Versions {
+		if newest == "" || compareVersions(version, newest) > 0 {
+			newest = version
+		}
+	}
+	if newest == "" {
+		return "dotnet"
+	}
+	return "dotnet@" + newest
+}
+
+// envManagerProvider returns the provider for the *env family of managers,
+// which share their commands and shell setup.
+func envManagerProvider(language, manager, rootVar, root string) runtimeProvider {
+	return runtimeProvider{
+		language: language,
+		formula:  manager,
+		command:  manager,
+		check: func(r Runner, version string) bool {
+			return listedVersion(r, version, manager, "versions", "--bare")
+		},
+		install: func(r Runner, version string) error {
+			return r.Run(manager, "install", "--skip-existing", version)
+		},
+		setDefault: func(r Runner, version string) error {
+			return r.Run(manager, "global", version)
+		},
+		env: staticEnv(
+			fmt.Sprintf(`export %s="%s"`, rootVar, root),
+			fmt.Sprintf(`export PATH="$%s/bin:$PATH"`, rootVar),
+			fmt.Sprintf(`eval "$(%s init -)"`, manager),
+		),
+	}
+}
+
+// nvm runs an nvm command. nvm is a shell function rather than a program, so
+// it has to be loaded into a shell first.
+func nvm(r Runner, args ...string) error {
+	script := `export NVM_DIR="$HOME/.nvm"; mkdir -p "$NVM_DIR"; . "$(brew --prefix nvm)/nvm.sh" && nvm "$@"`
+	return r.Run("bash", append([]string{"-c", script, "nvm"}, args...)...)
+}
+
+// listedVersion reports whether the output of a manager's list command has a
+// line naming version. A version also matches longer versions it is a prefix
+// of, so "3.12" is satisfied by "3.12.4" and "stable" by
+// "stable-aarch64-apple-darwin".
+func listedVersion(r Runner, version string, name string, args ...string) bool {
+	out, err := r.Output(name, args...)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(out, "\n") {
+		for _, field := range strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "*")) {
+			if field == version || strings.HasPrefix(field, version+".") || strings.HasPrefix(field, version+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runtimeManagers returns the names of the supported managers, sorted.
+func runtimeManagers() []string {
+	names := make([]string, 0, len(runtimeProviders))
+	for name := range runtimeProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// installRuntimes installs each runtime's manager and versions, selects the
+// default version and writes the managers' shell setup to profile. It returns
+// a line for each thing that went wrong, for the summary report.
+func installRuntimes(r Runner, runtimes []RuntimeConfig, profile string) []string {
+	clearScreen()
+	if len(runtimes) == 0 {
+		return nil
+	}
+
+	var failures []string
+	var env []string
+	for _, rt := range runtimes {
+		provider, ok := runtimeProviders[rt.Manager]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: unknown runtime manager", rt.Manager))
+			continue
+		}
+
+		fmt.Printf("Setting up %s with %s...\n", provider.language, rt.Manager)
+		failures = append(failures, installRuntime(r, rt, provider)...)
+		if provider.shellenv != nil {
+			exports, err := provider.shellenv(r, rt)
+			if err == nil {
+				err = applyShellExports(exports)
+			}
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s (%s): loading the environment: %v", provider.language, rt.Manager, err))
+			}
+		}
+
+		var lines []string
+		if provider.env != nil {
+			lines = provider.env(rt)
+		}
+		if len(rt.Env) > 0 {
+			lines = rt.Env
+		}
+		env = append(env, lines...)
+	}
+
+	changed, err := writeManagedBlock(profile, "runtimes", env)
+	if err != nil {
+		fmt.Printf("Error writing runtime environment to %s: %v\n", profile, err)
+		failures = append(failures, fmt.Sprintf("shell environment: %v", err))
+	} else if changed {
+		fmt.Printf("Updated the runtime environment in %s.\n", profile)
+	}
+
+	return failures
+}
+
+func installRuntime(r Runner, rt RuntimeConfig, provider runtimeProvider) []string {
+	var failures []string
+	fail := func(format string, args ...interface{}) {
+		message := fmt.Sprintf(format, args...)
+		fmt.Printf("%s: %s\n", rt.Manager, message)
+		failures = append(failures, fmt.Sprintf("%s (%s): %s", provider.language, rt.Manager, message))
+	}
+
+	if !managerInstalled(r, provider) {
+		fmt.Printf("Installing %s...\n", provider.formula)
+		if err := r.Run("brew", "install", provider.formula); err != nil {
+			fail("failed to install %s: %v", provider.formula, err)
+			return failures
+		}
+	}
+
+	for _, version := range rt.Versions {
+		if provider.check(r, version) {
+			fmt.Printf("%s %s is already installed.\n", provider.language, version)
+			continue
+		}
+		fmt.Printf("Installing %s %s...\n", provider.language, version)
+		if err := provider.install(r, version); err != nil {
+			fail("failed to install %s: %v", version, err)
+		}
+	}
+
+	if rt.Default != "" {
+		if provider.setDefault == nil {
+			fail("%s cannot set a default version", rt.Manager)
+		} else if err := provider.setDefault(r, rt.Default); err != nil {
+			fail("failed to set default version %s: %v", rt.Default, err)
+		}
+	}
+
+	return failures
+}
+
+// managerInstalled reports whether a provider's manager is available, either
+// on the PATH or as a Homebrew formula.
+func managerInstalled(r Runner, provider runtimeProvider) bool {
+	if provider.command != "" && commandWorks(r, provider.command, "--version") {
+		return true
+	}
+	return commandWorks(r, "brew", "list", "--formula", provider.formula)
+}
+
+// commandWorks reports whether the command runs successfully.
+func commandWorks(r Runner, name string, args ...string) bool {
+	_, err := r.Output(name, args...)
+	return err == nil
+}
+
+// checkRuntimes reports runtimes without a supported manager and defaults the
+// manager cannot select.
+func checkRuntimes(root *yaml.Node, problems *[]configProblem) {
+	runtimes := mappingValue(root, "runtimes")
+	if runtimes == nil || runtimes.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, item := range runtimes.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		manager := mappingValue(item, "manager")
+		if manager == nil || strings.TrimSpace(manager.Value) == "" {
+			*problems = append(*problems, newProblem(item, "runtime entry needs a manager (one of %s)", strings.Join(runtimeManagers(), ", ")))
+			continue
+		}
+
+		provider, ok := runtimeProviders[manager.Value]
+		if !ok {
+			*problems = append(*problems, newProblem(manager, "unknown runtime manager %q (use one of %s)", manager.Value, strings.Join(runtimeManagers(), ", ")))
+			continue
+		}
+		if value := mappingValue(item, "default"); value != nil && provider.setDefault == nil {
+			*problems = append(*problems, newProblem(value, "%s cannot set a default version", manager.Value))
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRuntimes(t *testing.T) {
+	content := `
+version: 3
+runtimes:
+  - manager: pyenv
+    versions: ["3.12", "3.11"]
+    default: "3.12"
+  - manager: rustup
+    versions: [stable]
+    env:
+      - export PATH="$HOME/.cargo/bin:$PATH"
+`
+	config, err := decodeConfig([]byte(content), formatYAML)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(config.Runtimes) != 2 || config.Runtimes[0].Default != "3.12" || len(config.Runtimes[1].Env) != 1 {
+		t.Errorf("Unexpected runtimes %+v", config.Runtimes)
+	}
+}
+
+func TestValidateRuntimes(t *testing.T) {
+	content := `version: 3
+runtimes:
+  - manager: pyen
+  - versions: ["1.23"]
+  - manager: dotnet
+    default: "8"
+`
+	want := []string{
+		`3:14: unknown runtime manager "pyen"`,
+		`4:5: runtime entry needs a manager`,
+		`6:14: dotnet cannot set a default version`,
+	}
+
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), problems)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(problems[i].String(), prefix) {
+			t.Errorf("Problem %d: expected %q, got %q", i, prefix, problems[i])
+		}
+	}
+}
+
+func TestListedVersion(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"pyenv versions --bare": {output: "3.11.9\n3.12.4\n"},
+		"rustup toolchain list": {output: "stable-aarch64-apple-darwin (default)\nnightly-aarch64-apple-darwin\n"},
+		"fnm list":              {output: "* v20.11.0 default\n* system\n"},
+		"goenv versions --bare": {err: errors.New("exit status 1")},
+		"dotnet --list-sdks":    {output: "8.0.100 [/opt/homebrew/Cellar/dotnet/8.0.100/libexec/sdk]\n"},
+		"jenv versions --bare":  {output: "21\n21.0\n"},
+	})
+
+	tests := []struct {
+		version string
+		name    string
+		args    []string
+		want    bool
+	}{
+		{"3.12", "pyenv", []string{"versions", "--bare"}, true},
+		{"3.12.4", "pyenv", []string{"versions", "--bare"}, true},
+		{"3.1", "pyenv", []string{"versions", "--bare"}, false},
+		{"3.13", "pyenv", []string{"versions", "--bare"}, false},
+		{"stable", "rustup", []string{"toolchain", "list"}, true},
+		{"beta", "rustup", []string{"toolchain", "list"}, false},
+		{"v20", "fnm", []string{"list"}, true},
+		{"1.23", "goenv", []string{"versions", "--bare"}, false},
+		{"8", "dotnet", []string{"--list-sdks"}, true},
+		{"17", "jenv", []string{"versions", "--bare"}, false},
+	}
+	for _, tt := range tests {
+		if got := listedVersion(r, tt.version, tt.name, tt.args...); got != tt.want {
+			t.Errorf("listedVersion(%q, %s): got %v, want %v", tt.version, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInstallRuntimes(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), ".zprofile")
+	if err := os.WriteFile(profile, []byte("export EDITOR=vim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newFakeRunner(map[string]fakeResult{
+		"pyenv versions --bare":         {output: "3.12.4\n"},
+		"fnm --version":                 {err: errors.New("executable file not found")},
+		"brew list --formula fnm":       {err: errors.New("exit status 1")},
+		"rustup --version":              {output: "rustup 1.27.1\n"},
+		"rustup toolchain install beta": {err: errors.New("exit status 1")},
+	})
+
+	runtimes := []RuntimeConfig{
+		{Manager: "pyenv", Versions: []string{"3.12", "3.11"}, Default: "3.12"},
+		{Manager: "fnm", Versions: []string{"20"}, Default: "20"},
+		{Manager: "rustup", Versions: []string{"beta"}, Env: []string{`export PATH="$HOME/.cargo/bin:$PATH"`}},
+	}
+
+	failures := installRuntimes(r, runtimes, profile)
+	if len(failures) != 1 || !strings.Contains(failures[0], "Rust (rustup): failed to install beta") {
+		t.Errorf("Expected the rustup failure to be reported, got %v", failures)
+	}
+
+	for _, call := range []string{
+		"pyenv install --skip-existing 3.11",
+		"pyenv global 3.12",
+		"brew install fnm",
+		"fnm install 20",
+		"fnm default 20",
+	} {
+		if !r.called(call) {
+			t.Errorf("Expected %q to run, calls: %v", call, r.calls)
+		}
+	}
+	for _, call := range []string{"pyenv install --skip-existing 3.12", "brew install pyenv", "brew install rustup"} {
+		if r.called(call) {
+			t.Errorf("Expected %q not to run", call)
+		}
+	}
+
+	data, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"export EDITOR=vim\n", `eval "$(pyenv init -)"`, `eval "$(fnm env --use-on-cd)"`, `export PATH="$HOME/.cargo/bin:$PATH"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected profile to contain %q, got:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), "brew --prefix rustup") {
+		t.Errorf("Expected env to replace the rustup defaults, got:\n%s", data)
+	}
+}
+
+func TestInstallRuntimesNVM(t *testing.T) {
+	r := newFakeRunner(nil)
+	installRuntimes(r, []RuntimeConfig{{Manager: "nvm", Versions: []string{"20"}}}, filepath.Join(t.TempDir(), ".zprofile"))
+
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "bash -c") && strings.HasSuffix(call, "nvm which 20") {
+			return
+		}
+	}
+	t.Errorf("Expected nvm to run in a shell, calls: %v", r.calls)
+}
+
+func TestInstallRuntimesDotNetEnv(t *testing.T) {
+	t.Setenv("DOTNET_ROOT", "")
+	r := newFakeRunner(map[string]fakeResult{
+		"dotnet --list-sdks":   {output: "8.0.401 [/opt/homebrew/Cellar/dotnet/8.0.8/libexec/sdk]\n"},
+		"brew --prefix dotnet": {output: "/opt/homebrew/opt/dotnet\n"},
+	})
+	failures := installRuntimes(r, []RuntimeConfig{{Manager: "dotnet"}}, filepath.Join(t.TempDir(), ".zprofile"))
+	if len(failures) != 0 {
+		t.Fatalf("Expected no failures, got %v", failures)
+	}
+	if got := os.Getenv("DOTNET_ROOT"); got != "/opt/homebrew/opt/dotnet/libexec" {
+		t.Errorf("Expected DOTNET_ROOT to be applied, got %q", got)
+	}
+}
+
+func TestInstallRuntimesDotNetEnvPinnedVersion(t *testing.T) {
+	t.Setenv("DOTNET_ROOT", "")
+	r := newFakeRunner(map[string]fakeResult{
+		"dotnet --list-sdks":     {output: "8.0.401 [/opt/homebrew/Cellar/dotnet@8/8.0.8/libexec/sdk]\n9.0.100 [/opt/homebrew/Cellar/dotnet@9/9.0.0/libexec/sdk]\n"},
+		"brew --prefix dotnet":   {output: "/opt/homebrew/opt/dotnet\n"},
+		"brew --prefix dotnet@9": {output: "/opt/homebrew/opt/dotnet@9\n"},
+	})
+	profile := filepath.Join(t.TempDir(), ".zprofile")
+	failures := installRuntimes(r, []RuntimeConfig{{Manager: "dotnet", Versions: []string{"8", "9"}}}, profile)
+	if len(failures) != 0 {
+		t.Fatalf("Expected no failures, got %v", failures)
+	}
+	if got := os.Getenv("DOTNET_ROOT"); got != "/opt/homebrew/opt/dotnet@9/libexec" {
+		t.Errorf("Expected DOTNET_ROOT for dotnet@9, got %q", got)
+	}
+	if content := string(mustRead(t, profile)); !strings.Contains(content, `export DOTNET_ROOT="$(brew --prefix dotnet@9)/libexec"`) {
+		t.Errorf("Expected the profile to export DOTNET_ROOT for dotnet@9, got:\n%s", content)
+	}
+}
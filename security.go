@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SecurityConfig is the security baseline the Mac is checked against. Each
+// check is required unless it is set to false. EnableFirewall turns the
+// firewall and stealth mode on when they are required but off; the other
+// settings are only reported, since changing them needs a restart or
+// Recovery.
+type SecurityConfig struct {
+	FileVault      *bool `yaml:"fileVault,omitempty" json:"fileVault,omitempty" toml:"fileVault,omitempty"`
+	Firewall       *bool `yaml:"firewall,omitempty" json:"firewall,omitempty" toml:"firewall,omitempty"`
+	StealthMode    *bool `yaml:"stealthMode,omitempty" json:"stealthMode,omitempty" toml:"stealthMode,omitempty"`
+	Gatekeeper     *bool `yaml:"gatekeeper,omitempty" json:"gatekeeper,omitempty" toml:"gatekeeper,omitempty"`
+	SIP            *bool `yaml:"sip,omitempty" json:"sip,omitempty" toml:"sip,omitempty"`
+	EnableFirewall bool  `yaml:"enableFirewall,omitempty" json:"enableFirewall,omitempty" toml:"enableFirewall,omitempty"`
+}
+
+// requires reports whether the baseline includes check.
+func (c SecurityConfig) requires(check securityCheck) bool {
+	required := check.required(c)
+	return required == nil || *required
+}
+
+// socketfilterfw controls the application firewall.
+const socketfilterfw = "/usr/libexec/ApplicationFirewall/socketfilterfw"
+
+// securityCheck reads one setting. Parse returns whether the setting is on,
+// a note on its state and whether the output was understood. Enable, if set,
+// is the sudo command that turns the setting on.
+type securityCheck struct {
+	name     string
+	required func(SecurityConfig) *bool
+	command  []string
+	parse    func(output string) (on bool, detail string, ok bool)
+	enable   []string
+}
+
+var securityChecks = []securityCheck{
+	{
+		name:     "FileVault",
+		required: func(c SecurityConfig) *bool { return c.FileVault },
+		command:  []string{"fdesetup", "status"},
+		parse:    parseFileVault,
+	},
+	{
+		name:     "Firewall",
+		required: func(c SecurityConfig) *bool { return c.Firewall },
+		command:  []string{socketfilterfw, "--getglobalstate"},
+		parse:    parseFirewall,
+		enable:   []string{socketfilterfw, "--setglobalstate", "on"},
+	},
+	{
+		name:     "Stealth mode",
+		required: func(c SecurityConfig) *bool { return c.StealthMode },
+		command:  []string{socketfilterfw, "--getstealthmode"},
+		parse:    parseStealthMode,
+		enable:   []string{socketfilterfw, "--setstealthmode", "on"},
+	},
+	{
+		name:     "Gatekeeper",
+		required: func(c SecurityConfig) *bool { return c.Gatekeeper },
+		command:  []string{"spctl", "--status"},
+		parse:    parseGatekeeper,
+	},
+	{
+		name:     "SIP",
+		required: func(c SecurityConfig) *bool { return c.SIP },
+		command:  []string{"csrutil", "status"},
+		parse:    parseSIP,
+	},
+}
+
+// parseFileVault reads `fdesetup status`. FileVault that is still encrypting
+// or waiting for a restart is not on yet.
+func parseFileVault(output string) (bool, string, bool) {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(output), "\n", 2)[0])
+	switch {
+	case strings.HasPrefix(line, "FileVault is On"):
+		return true, "", true
+	case strings.HasPrefix(line, "Encryption in progress"):
+		return false, "encryption in progress", true
+	case strings.HasPrefix(line, "Decryption in progress"):
+		return false, "decryption in progress", true
+	case strings.Contains(line, "will be enabled after the next restart"):
+		return false, "enabled after the next restart", true
+	case strings.HasPrefix(line, "FileVault is Off"):
+		return false, "", true
+	}
+	return false, "", false
+}
+
+// firewallState matches the state socketfilterfw prints: 0 is off, 1 is on
+// and 2 blocks all incoming connections.
+var firewallState = regexp.MustCompile(`\(State = (\d)\)`)
+
+// parseFirewall reads `socketfilterfw --getglobalstate`.
+func parseFirewall(output string) (bool, string, bool) {
+	m := firewallState.FindStringSubmatch(output)
+	switch {
+	case m == nil:
+		return false, "", false
+	case m[1] == "2":
+		return true, "blocking all incoming connections", true
+	}
+	return m[1] != "0", "", true
+}
+
+// parseStealthMode reads `socketfilterfw --getstealthmode`, which prints
+// "Stealth mode enabled" or, on newer macOS, "Firewall stealth mode is on".
+func parseStealthMode(output string) (bool, string, bool) {
+	output = strings.ToLower(output)
+	switch {
+	case strings.Contains(output, "enabled") || strings.Contains(output, "is on"):
+		return true, "", true
+	case strings.Contains(output, "disabled") || strings.Contains(output, "is off"):
+		return false, "", true
+	}
+	return false, "", false
+}
+
+// parseGatekeeper reads `spctl --status`.
+func parseGatekeeper(output string) (bool, string, bool) {
+	switch strings.TrimSpace(output) {
+	case "assessments enabled":
+		return true, "", true
+	case "assessments disabled":
+		return false, "", true
+	}
+	return false, "", false
+}
+
+// sipStatus matches the status line of `csrutil status`.
+var sipStatus = regexp.MustCompile(`System Integrity Protection status: ([^\n]*?)\.?\n`)
+
+// parseSIP reads `csrutil status`. A custom configuration, with some
+// protections turned off, does not count as on.
+func parseSIP(output string) (bool, string, bool) {
+	m := sipStatus.FindStringSubmatch(output + "\n")
+	if m == nil {
+		return false, "", false
+	}
+	switch status := strings.TrimSpace(m[1]); status {
+	case "enabled":
+		return true, "", true
+	case "disabled":
+		return false, "", true
+	default:
+		return false, status, true
+	}
+}
+
+// securityResult is the state of one check.
+type securityResult struct {
+	Check  string
+	On     bool
+	Detail string
+	Err    error
+}
+
+func (r securityResult) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("%s: could not be checked (%v)", r.Check, r.Err)
+	}
+	state := "off"
+	if r.On {
+		state = "on"
+	}
+	if r.Detail != "" {
+		state += " (" + r.Detail + ")"
+	}
+	return r.Check + ": " + state
+}
+
+// compliant reports whether the check meets the baseline.
+func (r securityResult) compliant() bool {
+	return r.Err == nil && r.On
+}
+
+// readSecurityCheck runs a check's command and parses its output. Some of
+// the commands exit with an error when the setting is off, so the output is
+// parsed before the error is looked at.
+func readSecurityCheck(r Runner, check securityCheck) securityResult {
+	out, err := r.Output(check.command[0], check.command[1:]...)
+	on, detail, ok := check.parse(out)
+	switch {
+	case ok:
+		return securityResult{Check: check.name, On: on, Detail: detail}
+	case err != nil:
+		return securityResult{Check: check.name, Err: err}
+	}
+	return securityResult{Check: check.name, Err: fmt.Errorf("unexpected output %q", strings.TrimSpace(out))}
+}
+
+// firewallFixes returns the required firewall settings that are off and that
+// enableFirewall would turn on.
+func firewallFixes(r Runner, config SecurityConfig) []securityCheck {
+	if !config.EnableFirewall {
+		return nil
+	}
+	var fixes []securityCheck
+	for _, check := range securityChecks {
+		if check.enable == nil || !config.requires(check) {
+			continue
+		}
+		if result := readSecurityCheck(r, check); result.Err == nil && !result.On {
+			fixes = append(fixes, check)
+		}
+	}
+	return fixes
+}
+
+// enableFirewall turns on the required firewall settings that are off.
+func enableFirewall(r Runner, config SecurityConfig) error {
+	clearScreen()
+	var failed []string
+	for _, check := range firewallFixes(r, config) {
+		fmt.Printf("Turning on %s...\n", strings.ToLower(check.name))
+		if err := r.Run("sudo", check.enable...); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", check.name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// checkSecurity checks the Mac against the baseline and returns the result of
+// each required check.
+func checkSecurity(r Runner, config SecurityConfig) []securityResult {
+	clearScreen()
+	fmt.Println("Checking the security baseline...")
+
+	var results []securityResult
+	for _, check := range securityChecks {
+		if !config.requires(check) {
+			continue
+		}
+		result := readSecurityCheck(r, check)
+		fmt.Println(result)
+		results = append(results, result)
+	}
+	return results
+}
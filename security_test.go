@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Captured output of the commands the security checks read.
+const (
+	fdesetupOn      = "FileVault is On.\n"
+	fdesetupOff     = "FileVault is Off.\n"
+	fdesetupPending = "FileVault is Off, but will be enabled after the next restart.\n"
+	fdesetupRunning = "Encryption in progress: Percent completed = 42.13\nFileVault is On.\n"
+
+	firewallOn      = "Firewall is enabled. (State = 1)\n"
+	firewallOff     = "Firewall is disabled. (State = 0)\n"
+	firewallBlocked = "Firewall is blocking all non-essential incoming connections. (State = 2)\n"
+
+	stealthOn      = "Stealth mode enabled\n"
+	stealthOff     = "Stealth mode disabled\n"
+	stealthSequoia = "Firewall stealth mode is on\n"
+
+	csrutilEnabled = "System Integrity Protection status: enabled.\n"
+	csrutilCustom  = `System Integrity Protection status: unknown (Custom Configuration).
+
+Configuration:
+	Apple Internal: disabled
+	Kext Signing: disabled
+	Filesystem Protections: enabled
+
+This is an unsupported configuration, likely to break in the future and leave your machine in an unknown state.
+`
+)
+
+func TestSecurityParsers(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func(string) (bool, string, bool)
+		output string
+		on     bool
+		detail string
+	}{
+		{"FileVault on", parseFileVault, fdesetupOn, true, ""},
+		{"FileVault off", parseFileVault, fdesetupOff, false, ""},
+		{"FileVault pending", parseFileVault, fdesetupPending, false, "enabled after the next restart"},
+		{"FileVault encrypting", parseFileVault, fdesetupRunning, false, "encryption in progress"},
+		{"firewall on", parseFirewall, firewallOn, true, ""},
+		{"firewall off", parseFirewall, firewallOff, false, ""},
+		{"firewall blocking", parseFirewall, firewallBlocked, true, "blocking all incoming connections"},
+		{"stealth on", parseStealthMode, stealthOn, true, ""},
+		{"stealth off", parseStealthMode, stealthOff, false, ""},
+		{"stealth on, macOS 15", parseStealthMode, stealthSequoia, true, ""},
+		{"Gatekeeper on", parseGatekeeper, "assessments enabled\n", true, ""},
+		{"Gatekeeper off", parseGatekeeper, "assessments disabled\n", false, ""},
+		{"SIP on", parseSIP, csrutilEnabled, true, ""},
+		{"SIP off", parseSIP, "System Integrity Protection status: disabled.\n", false, ""},
+		{"SIP custom", parseSIP, csrutilCustom, false, "unknown (Custom Configuration)"},
+	}
+	for _, test := range tests {
+		on, detail, ok := test.parse(test.output)
+		if !ok || on != test.on || detail != test.detail {
+			t.Errorf("%s: expected %v %q, got %v %q (ok %v)", test.name, test.on, test.detail, on, detail, ok)
+		}
+	}
+
+	for _, parse := range []func(string) (bool, string, bool){parseFileVault, parseFirewall, parseStealthMode, parseGatekeeper, parseSIP} {
+		if _, _, ok := parse("command not found\n"); ok {
+			t.Errorf("Expected unexpected output to be rejected")
+		}
+	}
+}
+
+func TestCheckSecurity(t *testing.T) {
+	off := false
+	r := newFakeRunner(map[string]fakeResult{
+		"fdesetup status":                    {output: fdesetupPending},
+		socketfilterfw + " --getglobalstate": {output: firewallOn},
+		socketfilterfw + " --getstealthmode": {output: stealthOff},
+		// spctl exits with an error when assessments are disabled.
+		"spctl --status": {output: "assessments disabled\n", err: errors.New("exit status 1")},
+		"csrutil status": {err: errors.New("executable file not found")},
+	})
+
+	var got []string
+	for _, result := range checkSecurity(r, SecurityConfig{StealthMode: &off}) {
+		if !result.compliant() {
+			got = append(got, result.String())
+		}
+	}
+	want := []string{
+		"FileVault: off (enabled after the next restart)",
+		"Gatekeeper: off",
+		"SIP: could not be checked (executable file not found)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if r.called(socketfilterfw + " --getstealthmode") {
+		t.Error("Expected a check set to false to be skipped")
+	}
+}
+
+func TestEnableFirewall(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		socketfilterfw + " --getglobalstate": {output: firewallOff},
+		socketfilterfw + " --getstealthmode": {output: stealthOn},
+	})
+	if fixes := firewallFixes(r, SecurityConfig{}); len(fixes) != 0 {
+		t.Errorf("Expected no fixes without enableFirewall, got %v", fixes)
+	}
+
+	config := SecurityConfig{EnableFirewall: true}
+	if fixes := firewallFixes(r, config); len(fixes) != 1 || fixes[0].name != "Firewall" {
+		t.Errorf("Expected the firewall to need turning on, got %v", fixes)
+	}
+	if err := enableFirewall(r, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !r.called("sudo " + socketfilterfw + " --setglobalstate on") {
+		t.Errorf("Expected the firewall to be turned on, calls: %v", r.calls)
+	}
+	if r.called("sudo " + socketfilterfw + " --setstealthmode on") {
+		t.Error("Expected stealth mode, already on, to be left alone")
+	}
+}
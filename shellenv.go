@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// shellExport is one variable set by a shell setup command such as
+// `brew shellenv`.
+type shellExport struct {
+	Name  string
+	Value string
+}
+
+var (
+	// exportLine matches `export NAME=value`.
+	exportLine = regexp.MustCompile(`^export ([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+	// unsetGuard matches the `[ -z "${NAME-}" ] || ` prefix Homebrew uses to
+	// only touch MANPATH when it is already set.
+	unsetGuard = regexp.MustCompile(`^\[ -z "\$\{([A-Za-z_][A-Za-z0-9_]*)-\}" \] \|\| (.*)$`)
+)
+
+// parseShellExports reads the export lines of POSIX shell setup output and
+// expands the variables in their values. lookup supplies the environment the
+// output would be evaluated in; later lines see the exports of earlier ones.
+// Anything else, such as zsh's fpath or eval lines, is ignored.
+func parseShellExports(output string, lookup func(string) (string, bool)) []shellExport {
+	exported := make(map[string]string)
+	env := func(name string) (string, bool) {
+		if value, ok := exported[name]; ok {
+			return value, true
+		}
+		return lookup(name)
+	}
+
+	var exports []shellExport
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ";")
+		if m := unsetGuard.FindStringSubmatch(line); m != nil {
+			if value, _ := env(m[1]); value == "" {
+				continue
+			}
+			line = m[2]
+		}
+
+		m := exportLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		value := m[2]
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = expandShell(value[1:len(value)-1], env)
+		default:
+			value = expandShell(value, env)
+		}
+		exported[m[1]] = value
+		exports = append(exports, shellExport{Name: m[1], Value: value})
+	}
+	return exports
+}
+
+// expandShell expands $NAME and ${NAME...} references in s. Of the parameter
+// expansions it supports the -, :-, +, :+ and # forms that shell setup
+// commands use; # removes a literal prefix rather than a pattern.
+func expandShell(s string, lookup func(string) (string, bool)) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(expandParameter(s[i+2:i+end], lookup))
+			i += end
+		case c == '$':
+			n := i + 1
+			for n < len(s) && isNameByte(s[n], n == i+1) {
+				n++
+			}
+			if n == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			value, _ := lookup(s[i+1 : n])
+			b.WriteString(value)
+			i = n - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// expandParameter expands the inside of a ${...} reference.
+func expandParameter(expr string, lookup func(string) (string, bool)) string {
+	n := 0
+	for n < len(expr) && isNameByte(expr[n], n == 0) {
+		n++
+	}
+	value, set := lookup(expr[:n])
+	op := expr[n:]
+	switch {
+	case strings.HasPrefix(op, ":-"):
+		if value == "" {
+			return expandShell(op[2:], lookup)
+		}
+	case strings.HasPrefix(op, "-"):
+		if !set {
+			return expandShell(op[1:], lookup)
+		}
+	case strings.HasPrefix(op, ":+"):
+		if value == "" {
+			return ""
+		}
+		return expandShell(op[2:], lookup)
+	case strings.HasPrefix(op, "+"):
+		if !set {
+			return ""
+		}
+		return expandShell(op[1:], lookup)
+	case strings.HasPrefix(op, "#"):
+		return strings.TrimPrefix(value, expandShell(op[1:], lookup))
+	}
+	return value
+}
+
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// applyShellExports sets the exports in gomacdeploy's own environment. The
+// Runner inherits it, so the commands of later steps see them too.
+func applyShellExports(exports []shellExport) error {
+	for _, export := range exports {
+		if err := os.Setenv(export.Name, export.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadShellEnv runs a command that prints shell setup, such as
+// `brew shellenv`, and applies its exports to gomacdeploy's environment.
+func loadShellEnv(r Runner, name string, args ...string) ([]shellExport, error) {
+	out, err := r.Output(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	exports := parseShellExports(out, os.LookupEnv)
+	return exports, applyShellExports(exports)
+}
+
+// prependPath puts dirs at the front of PATH, skipping the ones already on
+// it.
+func prependPath(dirs ...string) {
+	path := filepath.SplitList(os.Getenv("PATH"))
+	present := make(map[string]bool)
+	for _, dir := range path {
+		present[dir] = true
+	}
+
+	var add []string
+	for _, dir := range dirs {
+		if !present[dir] {
+			add = append(add, dir)
+		}
+	}
+	if len(add) > 0 {
+		os.Setenv("PATH", strings.Join(append(add, path...), string(os.PathListSeparator)))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// brewShellenv is `brew shellenv` output from Homebrew 4.0 on Apple silicon.
+const brewShellenv = `export HOMEBREW_PREFIX="/opt/homebrew";
+export HOMEBREW_CELLAR="/opt/homebrew/Cellar";
+export HOMEBREW_REPOSITORY="/opt/homebrew";
+export PATH="/opt/homebrew/bin:/opt/homebrew/sbin${PATH+:$PATH}";
+export MANPATH="/opt/homebrew/share/man${MANPATH+:$MANPATH}:";
+export INFOPATH="/opt/homebrew/share/info:${INFOPATH:-}";
+`
+
+// brewShellenvPathHelper is `brew shellenv` output from a Homebrew that sets
+// PATH through path_helper.
+const brewShellenvPathHelper = `export HOMEBREW_PREFIX="/usr/local";
+export HOMEBREW_CELLAR="/usr/local/Cellar";
+export HOMEBREW_REPOSITORY="/usr/local/Homebrew";
+fpath[1,0]="/usr/local/share/zsh/site-functions";
+eval "$(/usr/bin/env PATH_HELPER_ROOT="/usr/local" /usr/libexec/path_helper -s)"
+[ -z "${MANPATH-}" ] || export MANPATH=":${MANPATH#:}";
+export INFOPATH="/usr/local/share/info:${INFOPATH:-}";
+`
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestParseShellExports(t *testing.T) {
+	got := parseShellExports(brewShellenv, lookupIn(map[string]string{"PATH": "/usr/bin:/bin"}))
+	want := []shellExport{
+		{"HOMEBREW_PREFIX", "/opt/homebrew"},
+		{"HOMEBREW_CELLAR", "/opt/homebrew/Cellar"},
+		{"HOMEBREW_REPOSITORY", "/opt/homebrew"},
+		{"PATH", "/opt/homebrew/bin:/opt/homebrew/sbin:/usr/bin:/bin"},
+		{"MANPATH", "/opt/homebrew/share/man:"},
+		{"INFOPATH", "/opt/homebrew/share/info:"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	got = parseShellExports(brewShellenvPathHelper, lookupIn(map[string]string{"MANPATH": ":/usr/share/man", "INFOPATH": "/usr/share/info"}))
+	want = []shellExport{
+		{"HOMEBREW_PREFIX", "/usr/local"},
+		{"HOMEBREW_CELLAR", "/usr/local/Cellar"},
+		{"HOMEBREW_REPOSITORY", "/usr/local/Homebrew"},
+		{"MANPATH", ":/usr/share/man"},
+		{"INFOPATH", "/usr/local/share/info:/usr/share/info"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// The MANPATH line only runs when MANPATH is already set.
+	for _, export := range parseShellExports(brewShellenvPathHelper, lookupIn(nil)) {
+		if export.Name == "MANPATH" {
+			t.Errorf("Expected MANPATH to be left unset, got %q", export.Value)
+		}
+	}
+}
+
+func TestExpandShell(t *testing.T) {
+	lookup := lookupIn(map[string]string{"HOME": "/Users/me", "EMPTY": ""})
+	tests := map[string]string{
+		"$HOME/.goenv":        "/Users/me/.goenv",
+		"${HOME}/bin":         "/Users/me/bin",
+		"${EMPTY:-fallback}":  "fallback",
+		"${EMPTY-fallback}":   "",
+		"${UNSET-$HOME}":      "/Users/me",
+		"${EMPTY+set}":        "set",
+		"${EMPTY:+set}":       "",
+		"${HOME#/Users}":      "/me",
+		`cost \$5 $`:          "cost $5 $",
+		"$UNSET:${UNSET:-}:x": "::x",
+	}
+	for in, want := range tests {
+		if got := expandShell(in, lookup); got != want {
+			t.Errorf("%s: expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestExecRunnerSeesShellExports(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "from-homebrew")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$HOMEBREW_PREFIX\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", os.Getenv("PATH"))
+	t.Setenv("HOMEBREW_PREFIX", "")
+
+	if err := applyShellExports([]shellExport{{"HOMEBREW_PREFIX", dir}}); err != nil {
+		t.Fatal(err)
+	}
+	prependPath(dir)
+	out, err := execRunner{}.Output("from-homebrew")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.TrimSpace(out) != dir {
+		t.Errorf("Expected the command to see HOMEBREW_PREFIX, got %q", out)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultAllowedSigners is the allowed signers file used to verify SSH
+// signatures when the config names none.
+const defaultAllowedSigners = "~/.ssh/allowed_signers"
+
+// GitSigning turns on commit signing. Format is "ssh" (the default) or
+// "gpg". For ssh, Key is the private key to sign with and defaults to the
+// ssh section's key; for gpg, it is the key ID and defaults to the secret
+// key for the git email. Missing keys are generated.
+type GitSigning struct {
+	Format         string `yaml:"format,omitempty" json:"format,omitempty" toml:"format,omitempty"`
+	Key            string `yaml:"key,omitempty" json:"key,omitempty" toml:"key,omitempty"`
+	AllowedSigners string `yaml:"allowedSigners,omitempty" json:"allowedSigners,omitempty" toml:"allowedSigners,omitempty"`
+}
+
+// Signing formats.
+const (
+	signingSSH = "ssh"
+	signingGPG = "gpg"
+)
+
+// setupCommitSigning configures git to sign every commit. It returns the
+// public key to register with the git host as a signing key.
+func setupCommitSigning(r Runner, signing *GitSigning, ssh *SSHConfig) (string, error) {
+	clearScreen()
+	fmt.Println("Setting up commit signing...")
+
+	email, _ := r.Output("git", "config", "--global", "--get", "user.email")
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", fmt.Errorf("git user.email is not set")
+	}
+
+	var settings []gitSetting
+	var public string
+	var err error
+	switch signing.Format {
+	case "", signingSSH:
+		settings, public, err = sshSigningSettings(r, signing, ssh, email)
+	case signingGPG:
+		settings, public, err = gpgSigningSettings(r, signing, email)
+	default:
+		return "", fmt.Errorf("unknown signing format %q (use ssh or gpg)", signing.Format)
+	}
+	if err != nil {
+		return "", err
+	}
+	settings = append(settings, gitSetting{Key: "commit.gpgsign", Value: "true"})
+
+	changes, err := applyGitSettings(r, settings)
+	for _, change := range changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		fmt.Println("Commit signing is already set up.")
+	}
+	return public, nil
+}
+
+// sshSigningSettings signs with an SSH key, generating it if needed, and
+// lists it in the allowed signers file so git can verify local signatures.
+func sshSigningSettings(r Runner, signing *GitSigning, ssh *SSHConfig, email string) ([]gitSetting, string, error) {
+	key := signing.Key
+	if key == "" && ssh != nil {
+		key = ssh.keyPath()
+	}
+	if key == "" {
+		key = defaultSSHKey
+	}
+	key = expandHome(strings.TrimSuffix(key, ".pub"))
+
+	if err := ensureSSHKey(r, key, email); err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		return nil, "", err
+	}
+	public := strings.TrimSpace(string(data))
+
+	allowedSigners := signing.AllowedSigners
+	if allowedSigners == "" {
+		allowedSigners = defaultAllowedSigners
+	}
+	allowedSigners = expandHome(allowedSigners)
+	if err := addAllowedSigner(allowedSigners, email, public); err != nil {
+		return nil, "", fmt.Errorf("writing %s: %v", allowedSigners, err)
+	}
+
+	return []gitSetting{
+		{Key: "gpg.format", Value: "ssh"},
+		{Key: "user.signingkey", Value: key + ".pub"},
+		{Key: "gpg.ssh.allowedSignersFile", Value: allowedSigners},
+	}, public, nil
+}
+
+// addAllowedSigner adds "email namespaces="git" key" to the allowed signers
+// file unless the email is already listed with that key.
+func addAllowedSigner(path, email, publicKey string) error {
+	fields := strings.Fields(publicKey)
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed public key %q", publicKey)
+	}
+	keyType, keyData := fields[0], fields[1]
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		lineFields := strings.Fields(line)
+		if len(lineFields) > 0 && lineFields[0] == email && strings.Contains(line, keyType+" "+keyData) {
+			return nil
+		}
+	}
+
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += fmt.Sprintf("%s namespaces=\"git\" %s %s\n", email, keyType, keyData)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// gpgSigningSettings signs with a GPG key, generating one for the git
+// identity if there is none.
+func gpgSigningSettings(r Runner, signing *GitSigning, email string) ([]gitSetting, string, error) {
+	if !commandWorks(r, "gpg", "--version") {
+		fmt.Println("GnuPG is not installed. Installing gnupg...")
+		if err := r.Run("brew", "install", "gnupg"); err != nil {
+			return nil, "", fmt.Errorf("installing gnupg: %v", err)
+		}
+	}
+
+	keyID := signing.Key
+	if keyID == "" {
+		var err error
+		if keyID, err = gpgSigningKey(r, email); err != nil {
+			return nil, "", err
+		}
+	}
+
+	public, err := r.Output("gpg", "--armor", "--export", keyID)
+	if err != nil {
+		return nil, "", fmt.Errorf("exporting GPG key %s: %v", keyID, err)
+	}
+
+	return []gitSetting{
+		{Key: "gpg.format", Value: "openpgp"},
+		{Key: "user.signingkey", Value: keyID},
+	}, strings.TrimSpace(public), nil
+}
+
+// gpgSigningKey returns the ID of the secret key for email, generating an
+// ed25519 signing key if there is none.
+func gpgSigningKey(r Runner, email string) (string, error) {
+	if out, err := r.Output("gpg", "--list-secret-keys", "--with-colons", email); err == nil {
+		if keys := parseGPGSecretKeys(out); len(keys) > 0 {
+			fmt.Printf("Using the existing GPG key %s.\n", keys[0])
+			return keys[0], nil
+		}
+	}
+
+	name, _ := r.Output("git", "config", "--global", "--get", "user.name")
+	uid := fmt.Sprintf("%s <%s>", strings.TrimSpace(name), email)
+	fmt.Printf("Generating a GPG key for %s...\n", uid)
+	if err := r.Run("gpg", "--quick-generate-key", uid, "ed25519", "sign", "never"); err != nil {
+		return "", fmt.Errorf("generating a GPG key: %v", err)
+	}
+
+	out, err := r.Output("gpg", "--list-secret-keys", "--with-colons", email)
+	if err != nil {
+		return "", fmt.Errorf("listing GPG keys: %v", err)
+	}
+	keys := parseGPGSecretKeys(out)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no GPG key found for %s after generating one", email)
+	}
+	return keys[0], nil
+}
+
+// parseGPGSecretKeys returns the IDs of the usable secret keys in
+// `gpg --list-secret-keys --with-colons` output, skipping revoked, expired
+// and disabled keys and keys that cannot sign.
+func parseGPGSecretKeys(output string) []string {
+	var keys []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 12 || fields[0] != "sec" {
+			continue
+		}
+		if strings.ContainsAny(fields[1], "redni") {
+			continue
+		}
+		if !strings.ContainsAny(fields[11], "sS") {
+			continue
+		}
+		keys = append(keys, fields[4])
+	}
+	return keys
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const gpgSecretKeysOutput = `sec:r:255:22:REVOKED000000001:1700000000:::u:::scSC:::+:::ed25519:::0:
+fpr:::::::::AAAA:
+sec:u:255:22:ABCDEF0123456789:1700000000:::u:::scSC:::+:::ed25519:::0:
+fpr:::::::::BBBB:
+uid:u::::1700000000::HASH::Me <me@example.com>::::::::::0:
+sec:u:3072:1:ENCRYPTONLY00001:1700000000:::u:::eE:::+:::::0:
+`
+
+func TestParseGPGSecretKeys(t *testing.T) {
+	keys := parseGPGSecretKeys(gpgSecretKeysOutput)
+	if !reflect.DeepEqual(keys, []string{"ABCDEF0123456789"}) {
+		t.Errorf("Expected only the usable signing key, got %v", keys)
+	}
+}
+
+func TestSetupCommitSigningSSH(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	key := filepath.Join(home, ".ssh", "id_ed25519")
+	allowedSigners := filepath.Join(home, ".ssh", "allowed_signers")
+
+	r := keygenRunner{newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.email": {output: "me@example.com\n"},
+	})}
+
+	public, err := setupCommitSigning(r, &GitSigning{}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if public != "ssh-ed25519 AAAATEST me@example.com" {
+		t.Errorf("Unexpected public key %q", public)
+	}
+
+	for _, call := range []string{
+		"ssh-keygen -t ed25519 -C me@example.com -f " + key,
+		"git config --global --replace-all gpg.format ssh",
+		"git config --global --replace-all user.signingkey " + key + ".pub",
+		"git config --global --replace-all gpg.ssh.allowedSignersFile " + allowedSigners,
+		"git config --global --replace-all commit.gpgsign true",
+	} {
+		if !r.called(call) {
+			t.Errorf("Expected %q to run, calls: %v", call, r.calls)
+		}
+	}
+
+	// A second run must not list the key twice.
+	if _, err := setupCommitSigning(r, &GitSigning{}, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(allowedSigners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "me@example.com namespaces=\"git\" ssh-ed25519 AAAATEST\n" {
+		t.Errorf("Unexpected allowed signers file:\n%s", data)
+	}
+}
+
+func TestSetupCommitSigningReusesSSHKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	key := filepath.Join(home, ".ssh", "work")
+	os.MkdirAll(filepath.Dir(key), 0700)
+	os.WriteFile(key, []byte("PRIVATE\n"), 0600)
+	os.WriteFile(key+".pub", []byte("ssh-ed25519 AAAAWORK work\n"), 0644)
+
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.email": {output: "me@work.example.com\n"},
+	})
+
+	if _, err := setupCommitSigning(r, &GitSigning{}, &SSHConfig{Key: "~/.ssh/work"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !r.called("git config --global --replace-all user.signingkey " + key + ".pub") {
+		t.Errorf("Expected the ssh section's key to be used, calls: %v", r.calls)
+	}
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "ssh-keygen -t") {
+			t.Errorf("Expected the existing key to be reused, got %s", call)
+		}
+	}
+}
+
+func TestSetupCommitSigningGPG(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.email":                {output: "me@example.com\n"},
+		"gpg --list-secret-keys --with-colons me@example.com": {output: gpgSecretKeysOutput},
+		"gpg --armor --export ABCDEF0123456789":               {output: "-----BEGIN PGP PUBLIC KEY BLOCK-----\n...\n"},
+		"git config --global --get user.signingkey":           {output: "ABCDEF0123456789\n"},
+	})
+
+	public, err := setupCommitSigning(r, &GitSigning{Format: signingGPG}, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(public, "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		t.Errorf("Expected the armored public key, got %q", public)
+	}
+	if !r.called("git config --global --replace-all gpg.format openpgp") {
+		t.Errorf("Expected gpg.format openpgp, calls: %v", r.calls)
+	}
+	if r.called("git config --global --replace-all user.signingkey ABCDEF0123456789") {
+		t.Error("Expected an unchanged signing key not to be written")
+	}
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "gpg --quick-generate-key") {
+			t.Errorf("Expected the existing GPG key to be reused, got %s", call)
+		}
+	}
+}
+
+func TestSetupCommitSigningGPGGenerates(t *testing.T) {
+	r := &sequenceRunner{fakeRunner: newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.email": {output: "me@example.com\n"},
+		"git config --global --get user.name":  {output: "Me\n"},
+		"gpg --version":                        {err: errors.New("executable file not found")},
+	})}
+	r.outputs = map[string][]string{
+		"gpg --list-secret-keys --with-colons me@example.com": {"", gpgSecretKeysOutput},
+	}
+
+	if _, err := setupCommitSigning(r, &GitSigning{Format: signingGPG}, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, call := range []string{
+		"brew install gnupg",
+		"gpg --quick-generate-key Me <me@example.com> ed25519 sign never",
+		"git config --global --replace-all user.signingkey ABCDEF0123456789",
+	} {
+		if !r.called(call) {
+			t.Errorf("Expected %q to run, calls: %v", call, r.calls)
+		}
+	}
+}
+
+func TestSetupCommitSigningWithoutEmail(t *testing.T) {
+	if _, err := setupCommitSigning(newFakeRunner(nil), &GitSigning{}, nil); err == nil {
+		t.Error("Expected an error without a git email, got nil")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SoftwareUpdateConfig selects which macOS updates are installed. Updates
+// that need a restart are held back until the reboot at the end of the
+// deployment unless DeferRestart is false.
+type SoftwareUpdateConfig struct {
+	Mode         SoftwareUpdateMode `yaml:"mode,omitempty" json:"mode,omitempty" toml:"mode,omitempty"`
+	Labels       []string           `yaml:"labels,omitempty" json:"labels,omitempty" toml:"labels,omitempty"`
+	DeferRestart *bool              `yaml:"deferRestart,omitempty" json:"deferRestart,omitempty" toml:"deferRestart,omitempty"`
+}
+
+// SoftwareUpdateMode is the macOS update policy: "skip" does nothing,
+// "list-only" reports the available updates, "recommended" installs the
+// recommended ones except major upgrades, "security-only" installs security
+// updates and responses, "all" installs everything and "labels" installs the
+// updates named in labels. The default is "all".
+type SoftwareUpdateMode string
+
+const (
+	softwareUpdateSkip        SoftwareUpdateMode = "skip"
+	softwareUpdateList        SoftwareUpdateMode = "list-only"
+	softwareUpdateRecommended SoftwareUpdateMode = "recommended"
+	softwareUpdateSecurity    SoftwareUpdateMode = "security-only"
+	softwareUpdateAll         SoftwareUpdateMode = "all"
+	softwareUpdateLabels      SoftwareUpdateMode = "labels"
+)
+
+func (m *SoftwareUpdateMode) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	switch mode := SoftwareUpdateMode(value); mode {
+	case softwareUpdateSkip, softwareUpdateList, softwareUpdateRecommended, softwareUpdateSecurity, softwareUpdateAll, softwareUpdateLabels:
+		*m = mode
+		return nil
+	}
+	return fmt.Errorf("unknown software update mode %q (use skip, list-only, recommended, security-only, all or labels)", value)
+}
+
+func (s SoftwareUpdateConfig) mode() SoftwareUpdateMode {
+	if s.Mode == "" {
+		return softwareUpdateAll
+	}
+	return s.Mode
+}
+
+// installs reports whether the mode installs updates, which needs sudo.
+func (s SoftwareUpdateConfig) installs() bool {
+	return s.mode() != softwareUpdateSkip && s.mode() != softwareUpdateList
+}
+
+func (s SoftwareUpdateConfig) deferRestart() bool {
+	return s.DeferRestart == nil || *s.DeferRestart
+}
+
+// softwareUpdate is one entry of `softwareupdate -l`.
+type softwareUpdate struct {
+	Label       string
+	Title       string
+	Version     string
+	SizeKiB     int64
+	Recommended bool
+	// Restart is set for updates that need a restart or a shutdown.
+	Restart bool
+}
+
+// rapidSecurityResponse matches the "(a)" suffix of Rapid Security Response
+// versions, e.g. 13.4.1 (a).
+var rapidSecurityResponse = regexp.MustCompile(`\([a-z]\)$`)
+
+// security reports whether the update is a security update or response.
+func (u softwareUpdate) security() bool {
+	return strings.Contains(strings.ToLower(u.Title), "security") || rapidSecurityResponse.MatchString(u.Version)
+}
+
+// majorUpgrade reports whether the update is a macOS release newer than the
+// running major version.
+func (u softwareUpdate) majorUpgrade(currentMajor int) bool {
+	if !strings.HasPrefix(u.Title, "macOS") || currentMajor == 0 {
+		return false
+	}
+	major, err := strconv.Atoi(strings.SplitN(u.Version, ".", 2)[0])
+	return err == nil && major > currentMajor
+}
+
+func (u softwareUpdate) String() string {
+	var notes []string
+	if u.SizeKiB > 0 {
+		notes = append(notes, fmt.Sprintf("%d MB", u.SizeKiB/1024))
+	}
+	if u.Restart {
+		notes = append(notes, "restart")
+	}
+	line := u.Label
+	if len(notes) > 0 {
+		line += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return line
+}
+
+// parseSoftwareUpdates parses `softwareupdate -l` output, in which each
+// update is a "* Label: <label>" line followed by a line of comma-separated
+// fields such as "Title: macOS Ventura 13.6.1, Version: 13.6.1,
+// Size: 1234567KiB, Recommended: YES, Action: restart,".
+func parseSoftwareUpdates(output string) []softwareUpdate {
+	var updates []softwareUpdate
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if label, ok := strings.CutPrefix(line, "* Label: "); ok {
+			updates = append(updates, softwareUpdate{Label: strings.TrimSpace(label)})
+			continue
+		}
+		if len(updates) == 0 || !strings.HasPrefix(line, "Title: ") {
+			continue
+		}
+
+		update := &updates[len(updates)-1]
+		for _, field := range strings.Split(line, ", ") {
+			key, value, _ := strings.Cut(field, ": ")
+			value = strings.TrimSuffix(strings.TrimSpace(value), ",")
+			switch key {
+			case "Title":
+				update.Title = value
+			case "Version":
+				update.Version = value
+			case "Size":
+				update.SizeKiB = parseUpdateSize(value)
+			case "Recommended":
+				update.Recommended = value == "YES"
+			case "Action":
+				update.Restart = value == "restart" || value == "shut down"
+			}
+		}
+	}
+	return updates
+}
+
+// parseUpdateSize converts sizes such as 1234567KiB or 160748K to KiB.
+func parseUpdateSize(value string) int64 {
+	digits := strings.TrimRight(value, "KiB")
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// selectSoftwareUpdates returns the updates the config asks for.
+func selectSoftwareUpdates(config SoftwareUpdateConfig, updates []softwareUpdate, currentMajor int) []softwareUpdate {
+	labels := make(map[string]bool)
+	for _, label := range config.Labels {
+		labels[label] = true
+	}
+
+	var selected []softwareUpdate
+	for _, update := range updates {
+		var want bool
+		switch config.mode() {
+		case softwareUpdateRecommended:
+			want = update.Recommended && !update.majorUpgrade(currentMajor)
+		case softwareUpdateSecurity:
+			want = update.security()
+		case softwareUpdateAll:
+			want = true
+		case softwareUpdateLabels:
+			want = labels[update.Label]
+		}
+		if want {
+			selected = append(selected, update)
+		}
+	}
+	return selected
+}
+
+// macOSMajorVersion returns the major version of the running macOS, or 0 if
+// it cannot be determined.
+func macOSMajorVersion(r Runner) int {
+	out, err := r.Output("sw_vers", "-productVersion")
+	if err != nil {
+		return 0
+	}
+	major, _ := strconv.Atoi(strings.SplitN(strings.TrimSpace(out), ".", 2)[0])
+	return major
+}
+
+// updateMacOS lists the available macOS updates and installs the ones the
+// config selects. Updates that need a restart are returned instead of being
+// installed when restarts are deferred, for finishAndReboot to install.
+func updateMacOS(r Runner, config SoftwareUpdateConfig, report *summaryReport) []softwareUpdate {
+	clearScreen()
+	if config.mode() == softwareUpdateSkip {
+		fmt.Println("Skipping macOS updates.")
+		return nil
+	}
+
+	fmt.Println("Checking for macOS updates...")
+	out, err := r.Output("softwareupdate", "-l")
+	if err != nil {
+		fmt.Printf("Error listing macOS updates: %v\n", err)
+		report.add("macOS updates failed", err.Error())
+		return nil
+	}
+	updates := parseSoftwareUpdates(out)
+	if len(updates) == 0 {
+		fmt.Println("macOS is up to date.")
+		return nil
+	}
+
+	fmt.Println("Available updates:")
+	for _, update := range updates {
+		fmt.Printf("  %s\n", update)
+	}
+
+	if config.mode() == softwareUpdateList {
+		lines := make([]string, len(updates))
+		for i, update := range updates {
+			lines[i] = update.String()
+		}
+		report.add("Available macOS updates (not installed)", lines...)
+		return nil
+	}
+
+	selected := selectSoftwareUpdates(config, updates, macOSMajorVersion(r))
+	if config.mode() == softwareUpdateLabels {
+		found := make(map[string]bool)
+		for _, update := range selected {
+			found[update.Label] = true
+		}
+		var missing []string
+		for _, label := range config.Labels {
+			if !found[label] {
+				missing = append(missing, label)
+			}
+		}
+		report.add("macOS updates not found", missing...)
+	}
+
+	var now, deferred []string
+	var deferredUpdates []softwareUpdate
+	for _, update := range selected {
+		if update.Restart && config.deferRestart() {
+			deferred = append(deferred, update.Label)
+			deferredUpdates = append(deferredUpdates, update)
+			continue
+		}
+		now = append(now, update.Label)
+	}
+
+	if len(now) > 0 {
+		fmt.Printf("Installing %s...\n", strings.Join(now, ", "))
+		if err := r.Run("sudo", append([]string{"softwareupdate", "-i"}, now...)...); err != nil {
+			fmt.Printf("Error updating macOS: %v\n", err)
+			report.add("macOS updates failed", err.Error())
+		}
+	}
+	if len(deferred) > 0 {
+		fmt.Printf("Deferring %s until the final reboot.\n", strings.Join(deferred, ", "))
+	}
+	return deferredUpdates
+}
+
+// installDeferredUpdates installs the updates held back by updateMacOS and
+// lets softwareupdate restart the Mac.
+func installDeferredUpdates(r Runner, updates []softwareUpdate) error {
+	args := []string{"softwareupdate", "-i", "--restart"}
+	for _, update := range updates {
+		args = append(args, update.Label)
+	}
+	return r.Run("sudo", args...)
+}
+
+// checkSoftwareUpdate reports a labels mode without labels, and labels that
+// another mode ignores.
+func checkSoftwareUpdate(root *yaml.Node, problems *[]configProblem) {
+	section := mappingValue(root, "softwareUpdate")
+	if section == nil || section.Kind != yaml.MappingNode {
+		return
+	}
+	mode := mappingValue(section, "mode")
+	labels := mappingValue(section, "labels")
+	hasLabels := labels != nil && labels.Kind == yaml.SequenceNode && len(labels.Content) > 0
+
+	switch {
+	case mode != nil && mode.Value == string(softwareUpdateLabels) && !hasLabels:
+		*problems = append(*problems, newProblem(mode, "softwareUpdate.mode labels needs a non-empty \"labels\" list"))
+	case hasLabels && (mode == nil || mode.Value != string(softwareUpdateLabels)):
+		*problems = append(*problems, newWarning(labels, "softwareUpdate.labels is ignored unless mode is labels"))
+	}
+}
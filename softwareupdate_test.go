@@ -0,0 +1,164 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// capturedSoftwareUpdates is `softwareupdate -l` output captured on macOS 13.
+const capturedSoftwareUpdates = `Software Update Tool
+
+Finding available software
+Software Update found the following new or updated software:
+* Label: macOS Ventura 13.6.1-22G313
+	Title: macOS Ventura 13.6.1, Version: 13.6.1, Size: 1123480KiB, Recommended: YES, Action: restart,
+* Label: macOS Sonoma 14.1-23B74
+	Title: macOS Sonoma 14.1, Version: 14.1, Size: 12982012KiB, Recommended: YES, Action: restart,
+* Label: macOS Ventura 13.4.1 (a)-22F770820d
+	Title: macOS Ventura 13.4.1 (a), Version: 13.4.1 (a), Size: 176543KiB, Recommended: YES, Action: restart,
+* Label: Command Line Tools for Xcode-15.0
+	Title: Command Line Tools for Xcode, Version: 15.0, Size: 703205KiB, Recommended: YES,
+* Label: Safari17.1VenturaAuto-17.1
+	Title: Safari, Version: 17.1, Size: 160748K, Recommended: YES,
+* Label: BridgeOSUpdateCustomer
+	Title: BridgeOS Security Update, Version: 8.1, Size: 512K, Recommended: NO, Action: shut down,
+`
+
+func TestParseSoftwareUpdates(t *testing.T) {
+	updates := parseSoftwareUpdates(capturedSoftwareUpdates)
+	if len(updates) != 6 {
+		t.Fatalf("Expected 6 updates, got %d: %v", len(updates), updates)
+	}
+
+	want := softwareUpdate{
+		Label:       "macOS Ventura 13.6.1-22G313",
+		Title:       "macOS Ventura 13.6.1",
+		Version:     "13.6.1",
+		SizeKiB:     1123480,
+		Recommended: true,
+		Restart:     true,
+	}
+	if updates[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, updates[0])
+	}
+	if updates[3].Restart || updates[3].Version != "15.0" {
+		t.Errorf("Expected the CLT update not to need a restart, got %+v", updates[3])
+	}
+	if updates[4].SizeKiB != 160748 {
+		t.Errorf("Expected the K size to be parsed, got %d", updates[4].SizeKiB)
+	}
+	if !updates[5].Restart || updates[5].Recommended {
+		t.Errorf("Expected a shutdown update that is not recommended, got %+v", updates[5])
+	}
+
+	if updates := parseSoftwareUpdates("Software Update Tool\n\nFinding available software\nNo new software available.\n"); len(updates) != 0 {
+		t.Errorf("Expected no updates, got %v", updates)
+	}
+}
+
+func TestSelectSoftwareUpdates(t *testing.T) {
+	updates := parseSoftwareUpdates(capturedSoftwareUpdates)
+	labels := func(selected []softwareUpdate) string {
+		var names []string
+		for _, update := range selected {
+			names = append(names, update.Label)
+		}
+		return strings.Join(names, "|")
+	}
+
+	tests := []struct {
+		config SoftwareUpdateConfig
+		want   string
+	}{
+		{SoftwareUpdateConfig{Mode: softwareUpdateRecommended}, "macOS Ventura 13.6.1-22G313|macOS Ventura 13.4.1 (a)-22F770820d|Command Line Tools for Xcode-15.0|Safari17.1VenturaAuto-17.1"},
+		{SoftwareUpdateConfig{Mode: softwareUpdateSecurity}, "macOS Ventura 13.4.1 (a)-22F770820d|BridgeOSUpdateCustomer"},
+		{SoftwareUpdateConfig{Mode: softwareUpdateLabels, Labels: []string{"Safari17.1VenturaAuto-17.1"}}, "Safari17.1VenturaAuto-17.1"},
+		{SoftwareUpdateConfig{}, labels(updates)},
+	}
+	for _, test := range tests {
+		if got := labels(selectSoftwareUpdates(test.config, updates, 13)); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.config.mode(), test.want, got)
+		}
+	}
+}
+
+func TestUpdateMacOSDefersRestarts(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"softwareupdate -l":       {output: capturedSoftwareUpdates},
+		"sw_vers -productVersion": {output: "13.6\n"},
+	})
+	report := &summaryReport{}
+
+	deferred := updateMacOS(r, SoftwareUpdateConfig{Mode: softwareUpdateRecommended}, report)
+	if !r.called("sudo softwareupdate -i Command Line Tools for Xcode-15.0 Safari17.1VenturaAuto-17.1") {
+		t.Errorf("Expected the updates without a restart to be installed now, got %v", r.calls)
+	}
+	if len(deferred) != 2 || deferred[0].Label != "macOS Ventura 13.6.1-22G313" {
+		t.Errorf("Expected the restart updates to be deferred, got %v", deferred)
+	}
+
+	r = newFakeRunner(nil)
+	if err := installDeferredUpdates(r, deferred); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !r.called("sudo softwareupdate -i --restart macOS Ventura 13.6.1-22G313 macOS Ventura 13.4.1 (a)-22F770820d") {
+		t.Errorf("Expected the deferred updates to be installed with a restart, got %v", r.calls)
+	}
+}
+
+func TestUpdateMacOSWithoutDeferral(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{"softwareupdate -l": {output: capturedSoftwareUpdates}})
+	off := false
+
+	config := SoftwareUpdateConfig{Mode: softwareUpdateLabels, Labels: []string{"macOS Ventura 13.6.1-22G313", "Missing-1.0"}, DeferRestart: &off}
+	report := &summaryReport{}
+	if deferred := updateMacOS(r, config, report); len(deferred) != 0 {
+		t.Errorf("Expected nothing to be deferred, got %v", deferred)
+	}
+	if !r.called("sudo softwareupdate -i macOS Ventura 13.6.1-22G313") {
+		t.Errorf("Expected the labelled update to be installed, got %v", r.calls)
+	}
+	if len(report.sections) != 1 || report.sections[0].lines[0] != "Missing-1.0" {
+		t.Errorf("Expected the missing label to be reported, got %v", report.sections)
+	}
+}
+
+func TestUpdateMacOSListOnlyAndSkip(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{"softwareupdate -l": {output: capturedSoftwareUpdates}})
+	report := &summaryReport{}
+	updateMacOS(r, SoftwareUpdateConfig{Mode: softwareUpdateList}, report)
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "sudo") {
+			t.Errorf("Expected nothing to be installed, got %s", call)
+		}
+	}
+	if len(report.sections) != 1 || len(report.sections[0].lines) != 6 {
+		t.Errorf("Expected the updates in the summary, got %v", report.sections)
+	}
+	if line := report.sections[0].lines[1]; line != "macOS Sonoma 14.1-23B74 (12677 MB, restart)" {
+		t.Errorf("Unexpected summary line %q", line)
+	}
+
+	r = newFakeRunner(nil)
+	updateMacOS(r, SoftwareUpdateConfig{Mode: softwareUpdateSkip}, report)
+	if len(r.calls) != 0 {
+		t.Errorf("Expected skip to run nothing, got %v", r.calls)
+	}
+}
+
+func TestValidateSoftwareUpdate(t *testing.T) {
+	problems := validateConfig([]byte("version: 3\nsoftwareUpdate:\n  mode: labels\n"), formatYAML)
+	if len(problems) != 1 || problems[0].String() != `3:9: softwareUpdate.mode labels needs a non-empty "labels" list` {
+		t.Errorf("Expected missing labels problem, got %v", problems)
+	}
+
+	problems = validateConfig([]byte("version: 3\nsoftwareUpdate:\n  mode: all\n  labels: [Safari]\n"), formatYAML)
+	if len(problems) != 1 || !problems[0].Warning {
+		t.Errorf("Expected a warning about ignored labels, got %v", problems)
+	}
+
+	problems = validateConfig([]byte("version: 3\nsoftwareUpdate:\n  mode: weekly\n"), formatYAML)
+	if len(problems) != 1 || !strings.Contains(problems[0].String(), "unknown software update mode") {
+		t.Errorf("Expected an unknown mode problem, got %v", problems)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSSHKey is the key setupSSH generates when the config names none.
+const defaultSSHKey = "~/.ssh/id_ed25519"
+
+// SSHConfig sets up an SSH key and the SSH client config. An empty ssh
+// section still generates the default key.
+type SSHConfig struct {
+	Key     string    `yaml:"key,omitempty" json:"key,omitempty" toml:"key,omitempty"`
+	Comment string    `yaml:"comment,omitempty" json:"comment,omitempty" toml:"comment,omitempty"`
+	Copy    bool      `yaml:"copy,omitempty" json:"copy,omitempty" toml:"copy,omitempty"`
+	Hosts   []SSHHost `yaml:"hosts,omitempty" json:"hosts,omitempty" toml:"hosts,omitempty"`
+}
+
+// SSHHost is a Host entry in ~/.ssh/config. IdentityFile defaults to the
+// generated key.
+type SSHHost struct {
+	Host         string `yaml:"host" json:"host" toml:"host"`
+	HostName     string `yaml:"hostName,omitempty" json:"hostName,omitempty" toml:"hostName,omitempty"`
+	User         string `yaml:"user,omitempty" json:"user,omitempty" toml:"user,omitempty"`
+	Port         string `yaml:"port,omitempty" json:"port,omitempty" toml:"port,omitempty"`
+	IdentityFile string `yaml:"identityFile,omitempty" json:"identityFile,omitempty" toml:"identityFile,omitempty"`
+}
+
+// keyPath returns the configured private key path, as written in the config.
+func (s *SSHConfig) keyPath() string {
+	if s.Key == "" {
+		return defaultSSHKey
+	}
+	return s.Key
+}
+
+// sshConfigLines returns the lines of the managed ~/.ssh/config block. Host
+// entries come first because ssh uses the first value it finds for each
+// option.
+func sshConfigLines(s *SSHConfig) []string {
+	var lines []string
+	option := func(name, value string) {
+		if value != "" {
+			lines = append(lines, fmt.Sprintf("  %s %s", name, value))
+		}
+	}
+
+	for _, host := range s.Hosts {
+		identity := host.IdentityFile
+		if identity == "" {
+			identity = s.keyPath()
+		}
+		lines = append(lines, "Host "+host.Host)
+		option("HostName", host.HostName)
+		option("User", host.User)
+		option("Port", host.Port)
+		option("IdentityFile", identity)
+		option("IdentitiesOnly", "yes")
+		lines = append(lines, "")
+	}
+
+	lines = append(lines, "Host *")
+	option("UseKeychain", "yes")
+	option("AddKeysToAgent", "yes")
+	option("IdentityFile", s.keyPath())
+	return lines
+}
+
+// sshKeyComment returns the comment for a new key: the configured one, the
+// git email, or user@host.
+func sshKeyComment(r Runner, s *SSHConfig) string {
+	if s.Comment != "" {
+		return s.Comment
+	}
+	if email, err := r.Output("git", "config", "--global", "--get", "user.email"); err == nil && strings.TrimSpace(email) != "" {
+		return strings.TrimSpace(email)
+	}
+	vars := builtinVars()
+	return vars["user"] + "@" + vars["hostname"]
+}
+
+// ensureSSHKey generates an ed25519 key at path unless one is already there.
+func ensureSSHKey(r Runner, path, comment string) error {
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Using the existing key %s.\n", path)
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	fmt.Printf("Generating an ed25519 key in %s...\n", path)
+	if err := r.Run("ssh-keygen", "-t", "ed25519", "-C", comment, "-f", path); err != nil {
+		return fmt.Errorf("generating %s: %v", path, err)
+	}
+	return nil
+}
+
+// sshKeyLoaded reports whether the key is already in ssh-agent.
+func sshKeyLoaded(r Runner, publicKey string) bool {
+	fingerprint, err := r.Output("ssh-keygen", "-l", "-f", publicKey)
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(fingerprint)
+	if len(fields) < 2 {
+		return false
+	}
+	loaded, err := r.Output("ssh-add", "-l")
+	return err == nil && strings.Contains(loaded, fields[1])
+}
+
+// setupSSH generates the SSH key if it is missing, adds it to ssh-agent and
+// the keychain, writes the managed ~/.ssh/config block and prints the public
+// key, copying it to the clipboard if asked. Running it again leaves an
+// existing key and an up to date config alone. It returns the public key.
+func setupSSH(r Runner, s *SSHConfig) (string, error) {
+	clearScreen()
+	fmt.Println("Setting up SSH...")
+
+	sshDir := filepath.Join(os.Getenv("HOME"), ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return "", err
+	}
+
+	key := expandHome(s.keyPath())
+	publicKey := key + ".pub"
+	if err := ensureSSHKey(r, key, sshKeyComment(r, s)); err != nil {
+		return "", err
+	}
+
+	if sshKeyLoaded(r, publicKey) {
+		fmt.Println("The key is already in ssh-agent.")
+	} else if err := r.Run("ssh-add", "--apple-use-keychain", key); err != nil {
+		fmt.Printf("Error adding %s to ssh-agent: %v\n", key, err)
+	}
+
+	configPath := filepath.Join(sshDir, "config")
+	changed, err := writeManagedBlock(configPath, "ssh", sshConfigLines(s))
+	if err != nil {
+		return "", fmt.Errorf("writing %s: %v", configPath, err)
+	}
+	if changed {
+		if err := os.Chmod(configPath, 0600); err != nil {
+			return "", err
+		}
+		fmt.Printf("Updated %s.\n", configPath)
+	}
+
+	data, err := os.ReadFile(publicKey)
+	if err != nil {
+		return "", err
+	}
+	public := strings.TrimSpace(string(data))
+
+	fmt.Println()
+	fmt.Println("Your public key (add it to GitHub under Settings > SSH and GPG keys):")
+	fmt.Println(public)
+	if s.Copy {
+		if err := r.Run("sh", "-c", `pbcopy < "$1"`, "sh", publicKey); err != nil {
+			fmt.Printf("Error copying the public key: %v\n", err)
+		} else {
+			fmt.Println("The public key has been copied to the clipboard.")
+		}
+	}
+
+	return public, nil
+}
+
+// checkSSHHosts reports ssh.hosts entries without a host pattern.
+func checkSSHHosts(root *yaml.Node, problems *[]configProblem) {
+	ssh := mappingValue(root, "ssh")
+	if ssh == nil {
+		return
+	}
+	hosts := mappingValue(ssh, "hosts")
+	if hosts == nil || hosts.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, item := range hosts.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		if value := mappingValue(item, "host"); value == nil || strings.TrimSpace(value.Value) == "" {
+			*problems = append(*problems, newProblem(item, "ssh.hosts entry needs a non-empty \"host\""))
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keygenRunner is a fakeRunner whose ssh-keygen -f writes a key pair, so the
+// steps after key generation find the files they expect.
+type keygenRunner struct {
+	*fakeRunner
+}
+
+func (k keygenRunner) Run(name string, args ...string) error {
+	if name == "ssh-keygen" {
+		for i, arg := range args {
+			if arg == "-f" && i+1 < len(args) {
+				os.WriteFile(args[i+1], []byte("PRIVATE\n"), 0600)
+				os.WriteFile(args[i+1]+".pub", []byte("ssh-ed25519 AAAATEST me@example.com\n"), 0644)
+			}
+		}
+	}
+	return k.fakeRunner.Run(name, args...)
+}
+
+func TestSetupSSHGeneratesKey(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	key := filepath.Join(home, ".ssh", "id_ed25519")
+
+	r := keygenRunner{newFakeRunner(map[string]fakeResult{
+		"git config --global --get user.email": {output: "me@example.com\n"},
+	})}
+
+	config := &SSHConfig{Copy: true, Hosts: []SSHHost{{Host: "github.com", User: "git"}}}
+	public, err := setupSSH(r, config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if public != "ssh-ed25519 AAAATEST me@example.com" {
+		t.Errorf("Unexpected public key %q", public)
+	}
+
+	for _, call := range []string{
+		"ssh-keygen -t ed25519 -C me@example.com -f " + key,
+		"ssh-add --apple-use-keychain " + key,
+		`sh -c pbcopy < "$1" sh ` + key + ".pub",
+	} {
+		if !r.called(call) {
+			t.Errorf("Expected %q to run, calls: %v", call, r.calls)
+		}
+	}
+
+	configPath := filepath.Join(home, ".ssh", "config")
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# >>> gomacdeploy ssh >>>
+Host github.com
+  User git
+  IdentityFile ~/.ssh/id_ed25519
+  IdentitiesOnly yes
+
+Host *
+  UseKeychain yes
+  AddKeysToAgent yes
+  IdentityFile ~/.ssh/id_ed25519
+# <<< gomacdeploy ssh <<<
+`
+	if string(data) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", data, want)
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected ~/.ssh/config to be 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestSetupSSHIdempotent(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	sshDir := filepath.Join(home, ".ssh")
+	key := filepath.Join(sshDir, "work")
+
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(key, []byte("PRIVATE\n"), 0600)
+	os.WriteFile(key+".pub", []byte("ssh-ed25519 AAAAWORK\n"), 0644)
+	existing := "Host old\n  User me\n"
+	os.WriteFile(filepath.Join(sshDir, "config"), []byte(existing), 0600)
+
+	r := newFakeRunner(map[string]fakeResult{
+		"ssh-keygen -l -f " + key + ".pub": {output: "256 SHA256:abc me (ED25519)\n"},
+		"ssh-add -l":                       {output: "256 SHA256:abc me (ED25519)\n"},
+	})
+	config := &SSHConfig{Key: "~/.ssh/work"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := setupSSH(r, config); err != nil {
+			t.Fatalf("Run %d: expected no error, got %v", i, err)
+		}
+	}
+
+	for _, call := range r.calls {
+		if strings.HasPrefix(call, "ssh-keygen -t") || strings.HasPrefix(call, "ssh-add --apple-use-keychain") {
+			t.Errorf("Expected the existing, loaded key to be reused, got %s", call)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(sshDir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), existing) || strings.Count(string(data), "# >>> gomacdeploy ssh >>>") != 1 {
+		t.Errorf("Expected one managed block after the existing config, got:\n%s", data)
+	}
+	if !strings.Contains(string(data), "IdentityFile ~/.ssh/work") {
+		t.Errorf("Expected the configured key to be used, got:\n%s", data)
+	}
+}
+
+func TestValidateSSHHosts(t *testing.T) {
+	content := "version: 3\nssh:\n  hosts:\n    - user: git\n"
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != 1 || problems[0].String() != `4:7: ssh.hosts entry needs a non-empty "host"` {
+		t.Errorf("Expected missing host problem, got %v", problems)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// step is one stage of a deployment. Root marks the steps that run commands
+// with sudo, so gomacdeploy only asks for the password when the first of
+// them runs and --no-sudo can skip them.
+type step struct {
+	title string
+	root  bool
+	run   func()
+}
+
+// runSteps runs the steps in order. Before the first root step it calls
+// elevate to get sudo; a nil elevate, as with --no-sudo, or one that fails
+// skips the root steps instead. It returns the titles of the skipped steps.
+func runSteps(steps []step, elevate func() error) []string {
+	var skipped []string
+	for _, s := range steps {
+		if s.root {
+			if elevate == nil {
+				fmt.Printf("Skipping %s: it needs sudo.\n", s.title)
+				skipped = append(skipped, s.title)
+				continue
+			}
+			if err := elevate(); err != nil {
+				fmt.Printf("Skipping %s: %v\n", s.title, err)
+				skipped = append(skipped, s.title)
+				continue
+			}
+		}
+		s.run()
+	}
+	return skipped
+}
+
+// settingsNeedRoot reports whether any defaultSettings command uses sudo.
+func settingsNeedRoot(settings []string) bool {
+	for _, setting := range settings {
+		if strings.HasPrefix(strings.TrimSpace(setting), "sudo ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunStepsElevatesLazily(t *testing.T) {
+	var order []string
+	record := func(name string) func() {
+		return func() { order = append(order, name) }
+	}
+	steps := []step{
+		{"Casks", false, record("casks")},
+		{"macOS updates", true, record("updates")},
+		{"Dock", false, record("dock")},
+		{"Rosetta", true, record("rosetta")},
+	}
+
+	elevate := func() error {
+		order = append(order, "sudo")
+		return nil
+	}
+	if skipped := runSteps(steps, elevate); len(skipped) != 0 {
+		t.Errorf("Expected nothing to be skipped, got %v", skipped)
+	}
+	want := []string{"casks", "sudo", "updates", "dock", "sudo", "rosetta"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("Expected %v, got %v", want, order)
+	}
+}
+
+func TestRunStepsWithoutSudo(t *testing.T) {
+	var ran []string
+	steps := []step{
+		{"Casks", false, func() { ran = append(ran, "Casks") }},
+		{"macOS updates", true, func() { ran = append(ran, "macOS updates") }},
+		{"Command Line Tools", true, func() { ran = append(ran, "Command Line Tools") }},
+	}
+
+	skipped := runSteps(steps, nil)
+	if !reflect.DeepEqual(ran, []string{"Casks"}) {
+		t.Errorf("Expected only the unprivileged step to run, got %v", ran)
+	}
+	if want := []string{"macOS updates", "Command Line Tools"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("Expected %v to be skipped, got %v", want, skipped)
+	}
+
+	// A refused password skips the privileged steps the same way.
+	ran = nil
+	skipped = runSteps(steps, func() error { return errors.New("sudo is not available") })
+	if len(ran) != 1 || len(skipped) != 2 {
+		t.Errorf("Expected the privileged steps to be skipped, ran %v, skipped %v", ran, skipped)
+	}
+}
+
+func TestSettingsNeedRoot(t *testing.T) {
+	if settingsNeedRoot([]string{"defaults write -g AppleShowAllExtensions -bool true"}) {
+		t.Error("Expected defaults write not to need root")
+	}
+	if !settingsNeedRoot([]string{"defaults write -g KeyRepeat -int 2", "  sudo pmset -a displaysleep 15"}) {
+		t.Error("Expected a sudo command to need root")
+	}
+}
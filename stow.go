@@ -0,0 +1,585 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// stowLocalIgnore is the per-package ignore file. As in GNU Stow, its
+// patterns replace the default ones.
+const stowLocalIgnore = ".stow-local-ignore"
+
+// defaultStowIgnore is GNU Stow's default ignore list, plus .DS_Store.
+// Patterns containing a slash match the path from the package root, the
+// others match a file name.
+var defaultStowIgnore = []string{
+	`RCS`, `.+,v`, `CVS`, `\.\#.+`, `\.cvsignore`, `\.svn`, `_darcs`, `\.hg`,
+	`\.git`, `\.gitignore`, `\.gitmodules`, `.+~`, `\#.*\#`, `\.DS_Store`,
+	`^/README.*`, `^/LICENSE.*`, `^/COPYING`,
+}
+
+// templateSuffix marks dotfiles that are rendered by renderDotfileTemplates
+// rather than linked.
+const templateSuffix = ".tmpl"
+
+// stowOptions control how packages are linked. Packages are directories in
+// dir whose contents are mirrored into target.
+type stowOptions struct {
+	dir       string
+	target    string
+	ignore    []string
+	adopt     bool
+	noFolding bool
+	// backup, when set, moves conflicting files and foreign links into this
+	// directory instead of reporting them.
+	backup string
+}
+
+// stowAction is one filesystem change. For links, source is the link text;
+// for adopt and backup, it is where the file at path is moved to.
+type stowAction struct {
+	kind   string
+	path   string
+	source string
+}
+
+const (
+	stowLink   = "link"
+	stowUnlink = "unlink"
+	stowMkdir  = "mkdir"
+	stowRmdir  = "rmdir"
+	stowAdopt  = "adopt"
+	stowBackup = "backup"
+)
+
+func (a stowAction) String() string {
+	switch a.kind {
+	case stowLink:
+		return fmt.Sprintf("link %s => %s", a.path, a.source)
+	case stowAdopt, stowBackup:
+		return fmt.Sprintf("%s %s to %s", a.kind, a.path, a.source)
+	}
+	return fmt.Sprintf("%s %s", a.kind, a.path)
+}
+
+// stowConflict is a path stow will not touch.
+type stowConflict struct {
+	pkg    string
+	path   string
+	reason string
+}
+
+func (c stowConflict) String() string {
+	return fmt.Sprintf("%s: %s: %s", c.pkg, c.path, c.reason)
+}
+
+// stowNode is the planned state of a target path. A nil node records that the
+// path is removed.
+type stowNode struct {
+	dir  bool
+	link string
+}
+
+// stowPlan computes the actions for a set of packages without touching the
+// target. Planned changes are recorded in overlay, so later decisions, such
+// as unfolding a directory another package folded in the same run, see them.
+type stowPlan struct {
+	opts      stowOptions
+	actions   []stowAction
+	conflicts []stowConflict
+	overlay   map[string]*stowNode
+}
+
+func newStowPlan(opts stowOptions) *stowPlan {
+	opts.dir, _ = filepath.Abs(opts.dir)
+	opts.target, _ = filepath.Abs(opts.target)
+	return &stowPlan{opts: opts, overlay: make(map[string]*stowNode)}
+}
+
+// Kinds of target path returned by lookup.
+const (
+	stowMissing = iota
+	stowIsFile
+	stowIsDir
+	stowIsLink
+)
+
+// lookup returns the planned state of path: its kind and, for links, the link
+// text.
+func (p *stowPlan) lookup(path string) (int, string) {
+	if node, ok := p.overlay[path]; ok {
+		switch {
+		case node == nil:
+			return stowMissing, ""
+		case node.dir:
+			return stowIsDir, ""
+		}
+		return stowIsLink, node.link
+	}
+	if p.shadowed(path) {
+		return stowMissing, ""
+	}
+
+	info, err := os.Lstat(path)
+	switch {
+	case err != nil:
+		return stowMissing, ""
+	case info.Mode()&os.ModeSymlink != 0:
+		link, _ := os.Readlink(path)
+		return stowIsLink, link
+	case info.IsDir():
+		return stowIsDir, ""
+	}
+	return stowIsFile, ""
+}
+
+// shadowed reports whether a planned change to a parent of path hides what
+// is on disk at path, e.g. when a folded directory link is replaced by a new
+// directory.
+func (p *stowPlan) shadowed(path string) bool {
+	for dir := filepath.Dir(path); dir != path && strings.HasPrefix(dir, p.opts.target); path, dir = dir, filepath.Dir(dir) {
+		if _, ok := p.overlay[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readDir returns the names in the planned directory path.
+func (p *stowPlan) readDir(path string) []string {
+	names := make(map[string]bool)
+	if _, ok := p.overlay[path]; !ok && !p.shadowed(path) {
+		if entries, err := os.ReadDir(path); err == nil {
+			for _, entry := range entries {
+				names[entry.Name()] = true
+			}
+		}
+	}
+	for planned, node := range p.overlay {
+		if filepath.Dir(planned) == path {
+			names[filepath.Base(planned)] = node != nil
+		}
+	}
+
+	var list []string
+	for name, present := range names {
+		if present {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (p *stowPlan) add(kind, path, source string) {
+	p.actions = append(p.actions, stowAction{kind: kind, path: path, source: source})
+	switch kind {
+	case stowLink:
+		p.overlay[path] = &stowNode{link: source}
+	case stowMkdir:
+		p.overlay[path] = &stowNode{dir: true}
+	case stowUnlink, stowRmdir, stowAdopt, stowBackup:
+		p.overlay[path] = nil
+	}
+}
+
+func (p *stowPlan) conflict(pkg, path, format string, args ...interface{}) {
+	p.conflicts = append(p.conflicts, stowConflict{pkg: pkg, path: path, reason: fmt.Sprintf(format, args...)})
+}
+
+// resolve returns the absolute path a link at path points to.
+func resolveLink(path, link string) string {
+	if filepath.IsAbs(link) {
+		return filepath.Clean(link)
+	}
+	return filepath.Join(filepath.Dir(path), link)
+}
+
+// owned reports whether an absolute path lies inside the stow directory, i.e.
+// whether a link to it was made by stow.
+func (p *stowPlan) owned(path string) bool {
+	rel, err := filepath.Rel(p.opts.dir, path)
+	return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+}
+
+// linkText returns the relative link from dest to source.
+func linkText(dest, source string) string {
+	link, err := filepath.Rel(filepath.Dir(dest), source)
+	if err != nil {
+		return source
+	}
+	return link
+}
+
+// ignorer decides which package paths are skipped.
+type ignorer struct {
+	names []*regexp.Regexp
+	paths []*regexp.Regexp
+	tails []*regexp.Regexp
+}
+
+// newIgnorer compiles the ignore rules for the package at pkgDir: the
+// package's own ignore file or the defaults, plus the extra patterns, which
+// match the end of the path.
+func newIgnorer(pkgDir string, extra []string) (*ignorer, error) {
+	patterns := defaultStowIgnore
+	if data, err := os.ReadFile(filepath.Join(pkgDir, stowLocalIgnore)); err == nil {
+		patterns = nil
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+
+	ig := &ignorer{}
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			re, err := regexp.Compile(`^(?:` + strings.TrimPrefix(pattern, "^") + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("ignore pattern %q: %v", pattern, err)
+			}
+			ig.paths = append(ig.paths, re)
+			continue
+		}
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("ignore pattern %q: %v", pattern, err)
+		}
+		ig.names = append(ig.names, re)
+	}
+	for _, pattern := range extra {
+		re, err := regexp.Compile(`(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("ignore pattern %q: %v", pattern, err)
+		}
+		ig.tails = append(ig.tails, re)
+	}
+	return ig, nil
+}
+
+// ignored reports whether rel, a slash-separated path from the package root,
+// is skipped.
+func (ig *ignorer) ignored(rel string) bool {
+	name := filepath.Base(rel)
+	if name == stowLocalIgnore {
+		return true
+	}
+	for _, re := range ig.names {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	for _, re := range ig.paths {
+		if re.MatchString("/" + rel) {
+			return true
+		}
+	}
+	for _, re := range ig.tails {
+		if re.MatchString("/" + rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// stow plans linking the package into the target.
+func (p *stowPlan) stow(pkg string) error {
+	pkgDir := filepath.Join(p.opts.dir, pkg)
+	if info, err := os.Stat(pkgDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("package %q not found in %s", pkg, p.opts.dir)
+	}
+	ig, err := newIgnorer(pkgDir, p.opts.ignore)
+	if err != nil {
+		return err
+	}
+	p.stowDir(pkg, ig, pkgDir, "")
+	return nil
+}
+
+// stowDir mirrors the package directory source/rel into the target.
+func (p *stowPlan) stowDir(pkg string, ig *ignorer, root, rel string) {
+	entries, err := os.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		p.conflict(pkg, filepath.Join(root, rel), "cannot read: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		childRel := filepath.Join(rel, entry.Name())
+		if ig.ignored(filepath.ToSlash(childRel)) {
+			continue
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), templateSuffix) {
+			continue
+		}
+		source := filepath.Join(root, childRel)
+		dest := filepath.Join(p.opts.target, childRel)
+		sourceIsDir := entry.IsDir()
+
+		kind, link := p.lookup(dest)
+		switch kind {
+		case stowMissing:
+			// A directory holding templates is not folded, or the rendered
+			// files would be written into the package.
+			if sourceIsDir && (p.opts.noFolding || hasTemplates(source)) {
+				p.add(stowMkdir, dest, "")
+				p.stowDir(pkg, ig, root, childRel)
+				continue
+			}
+			p.add(stowLink, dest, linkText(dest, source))
+
+		case stowIsLink:
+			resolved := resolveLink(dest, link)
+			switch {
+			case resolved == source:
+				// Already linked.
+			case !p.owned(resolved):
+				if p.opts.backup != "" {
+					p.add(stowBackup, dest, filepath.Join(p.opts.backup, childRel))
+					p.add(stowLink, dest, linkText(dest, source))
+					continue
+				}
+				p.conflict(pkg, dest, "existing link to %s is not owned by gomacdeploy", link)
+			case sourceIsDir && isDir(resolved) && foldedRoot(resolved, childRel) != "":
+				// Another package folded this directory: unfold it so both
+				// packages can share it.
+				other := foldedRoot(resolved, childRel)
+				otherIgnore, err := newIgnorer(other, p.opts.ignore)
+				if err != nil {
+					p.conflict(pkg, dest, "cannot unfold: %v", err)
+					continue
+				}
+				p.add(stowUnlink, dest, "")
+				p.add(stowMkdir, dest, "")
+				p.stowDir(filepath.Base(other), otherIgnore, other, childRel)
+				p.stowDir(pkg, ig, root, childRel)
+			default:
+				p.conflict(pkg, dest, "already linked to %s", link)
+			}
+
+		case stowIsDir:
+			if sourceIsDir {
+				p.stowDir(pkg, ig, root, childRel)
+				continue
+			}
+			p.conflict(pkg, dest, "a directory is in the way of the package file")
+
+		case stowIsFile:
+			switch {
+			case sourceIsDir:
+				p.conflict(pkg, dest, "a file is in the way of the package directory")
+			case p.opts.adopt:
+				p.add(stowAdopt, dest, source)
+				p.add(stowLink, dest, linkText(dest, source))
+			case p.opts.backup != "":
+				p.add(stowBackup, dest, filepath.Join(p.opts.backup, childRel))
+				p.add(stowLink, dest, linkText(dest, source))
+			default:
+				p.conflict(pkg, dest, "existing file would be replaced (use --adopt to move it into the package)")
+			}
+		}
+	}
+}
+
+// foldedRoot returns the package directory of a folded link to resolved made
+// for rel, or "" if the link does not mirror rel.
+func foldedRoot(resolved, rel string) string {
+	root, ok := strings.CutSuffix(resolved, string(filepath.Separator)+rel)
+	if !ok {
+		return ""
+	}
+	return root
+}
+
+// hasTemplates reports whether there is a template anywhere under dir.
+func hasTemplates(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(path, templateSuffix) {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// unstow plans removing the package's links from the target.
+func (p *stowPlan) unstow(pkg string) error {
+	pkgDir := filepath.Join(p.opts.dir, pkg)
+	if info, err := os.Stat(pkgDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("package %q not found in %s", pkg, p.opts.dir)
+	}
+	ig, err := newIgnorer(pkgDir, p.opts.ignore)
+	if err != nil {
+		return err
+	}
+	p.unstowDir(pkg, ig, pkgDir, "")
+	return nil
+}
+
+func (p *stowPlan) unstowDir(pkg string, ig *ignorer, root, rel string) {
+	entries, err := os.ReadDir(filepath.Join(root, rel))
+	if err != nil {
+		return
+	}
+
+	// Links to files since removed from the package are dangling; drop them.
+	destDir := filepath.Join(p.opts.target, rel)
+	for _, name := range p.readDir(destDir) {
+		dest := filepath.Join(destDir, name)
+		if kind, link := p.lookup(dest); kind == stowIsLink {
+			resolved := resolveLink(dest, link)
+			if _, err := os.Lstat(resolved); os.IsNotExist(err) && strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+				p.add(stowUnlink, dest, "")
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		childRel := filepath.Join(rel, entry.Name())
+		if ig.ignored(filepath.ToSlash(childRel)) {
+			continue
+		}
+		source := filepath.Join(root, childRel)
+		dest := filepath.Join(p.opts.target, childRel)
+
+		kind, link := p.lookup(dest)
+		switch {
+		case kind == stowIsLink && resolveLink(dest, link) == source:
+			p.add(stowUnlink, dest, "")
+		case kind == stowIsDir && entry.IsDir():
+			stowOnly := p.stowOnly(dest)
+			p.unstowDir(pkg, ig, root, childRel)
+			p.refold(dest, stowOnly)
+		}
+	}
+}
+
+// refold tidies a directory after links were removed from it. A directory
+// left holding nothing but links into one other package directory is replaced
+// by a single link to that directory, undoing an earlier unfold. A directory
+// that held only stow links and is now empty is removed; one that held
+// anything else belongs to the user and is kept.
+func (p *stowPlan) refold(dir string, stowOnly bool) {
+	names := p.readDir(dir)
+	if len(names) == 0 {
+		if stowOnly {
+			p.add(stowRmdir, dir, "")
+		}
+		return
+	}
+
+	parent := ""
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		kind, link := p.lookup(path)
+		if kind != stowIsLink {
+			return
+		}
+		resolved := resolveLink(path, link)
+		if !p.owned(resolved) || filepath.Base(resolved) != name {
+			return
+		}
+		if parent == "" {
+			parent = filepath.Dir(resolved)
+		} else if filepath.Dir(resolved) != parent {
+			return
+		}
+	}
+	rel, err := filepath.Rel(p.opts.target, dir)
+	if err != nil || foldedRoot(parent, rel) == "" {
+		return
+	}
+
+	for _, name := range names {
+		p.add(stowUnlink, filepath.Join(dir, name), "")
+	}
+	p.add(stowRmdir, dir, "")
+	p.add(stowLink, dir, linkText(dir, parent))
+}
+
+// stowOnly reports whether dir holds nothing but links into the stow
+// directory, which is what a directory stow unfolded looks like.
+func (p *stowPlan) stowOnly(dir string) bool {
+	names := p.readDir(dir)
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		kind, link := p.lookup(path)
+		if kind != stowIsLink || !p.owned(resolveLink(path, link)) {
+			return false
+		}
+	}
+	return len(names) > 0
+}
+
+// apply carries out the planned actions.
+func (p *stowPlan) apply() error {
+	for _, action := range p.actions {
+		var err error
+		switch action.kind {
+		case stowLink:
+			err = os.Symlink(action.source, action.path)
+		case stowUnlink, stowRmdir:
+			err = os.Remove(action.path)
+		case stowMkdir:
+			err = os.Mkdir(action.path, 0755)
+		case stowAdopt:
+			err = os.Rename(action.path, action.source)
+		case stowBackup:
+			if err = os.MkdirAll(filepath.Dir(action.source), 0755); err == nil {
+				err = os.Rename(action.path, action.source)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", action, err)
+		}
+	}
+	return nil
+}
+
+// Operations for stowPackages.
+const (
+	stowOpLink   = "link"
+	stowOpUnlink = "unlink"
+	stowOpRelink = "relink"
+)
+
+// stowPackages links the packages, removes their links, or with relink does
+// both, which picks up files added to or removed from a package. Nothing is
+// changed if any package has a conflict, so a run either completes or leaves
+// the target as it was. With dryRun, the plan is returned without being
+// applied.
+func stowPackages(opts stowOptions, packages []string, op string, dryRun bool) ([]stowAction, []stowConflict, error) {
+	plan := newStowPlan(opts)
+	if op == stowOpUnlink || op == stowOpRelink {
+		for _, pkg := range packages {
+			if err := plan.unstow(pkg); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if op == stowOpLink || op == stowOpRelink {
+		for _, pkg := range packages {
+			if err := plan.stow(pkg); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if len(plan.conflicts) > 0 || dryRun {
+		return plan.actions, plan.conflicts, nil
+	}
+	return plan.actions, nil, plan.apply()
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newStowDirs creates a stow directory with the given files and an empty
+// target, returning both.
+func newStowDirs(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "dotfiles")
+	target := filepath.Join(root, "home")
+	if err := os.MkdirAll(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, target
+}
+
+func TestStowFoldsDirectories(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{
+		"zsh/.zshrc":                 "zsh\n",
+		"nvim/.config/nvim/init.lua": "nvim\n",
+	})
+
+	opts := stowOptions{dir: dir, target: target}
+	if _, conflicts, err := stowPackages(opts, []string{"zsh", "nvim"}, stowOpLink, false); err != nil || len(conflicts) > 0 {
+		t.Fatalf("Expected no error, got %v, %v", conflicts, err)
+	}
+
+	if link := readLink(t, filepath.Join(target, ".zshrc")); link != "../dotfiles/zsh/.zshrc" {
+		t.Errorf("Expected a relative link, got %s", link)
+	}
+	if link := readLink(t, filepath.Join(target, ".config")); link != "../dotfiles/nvim/.config" {
+		t.Errorf("Expected .config to be folded into one link, got %s", link)
+	}
+
+	// Linking again changes nothing.
+	actions, _, err := stowPackages(opts, []string{"zsh", "nvim"}, stowOpLink, false)
+	if err != nil || len(actions) != 0 {
+		t.Errorf("Expected nothing to do, got %v, %v", actions, err)
+	}
+}
+
+func TestStowUnfoldsAndRefolds(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{
+		"nvim/.config/nvim/init.lua": "nvim\n",
+		"fish/.config/fish/config":   "fish\n",
+	})
+	opts := stowOptions{dir: dir, target: target}
+
+	if _, _, err := stowPackages(opts, []string{"nvim"}, stowOpLink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, conflicts, err := stowPackages(opts, []string{"fish"}, stowOpLink, false); err != nil || len(conflicts) > 0 {
+		t.Fatalf("Expected no error, got %v, %v", conflicts, err)
+	}
+
+	config := filepath.Join(target, ".config")
+	if info, err := os.Lstat(config); err != nil || !info.IsDir() {
+		t.Fatalf("Expected .config to be unfolded into a directory, got %v, %v", info, err)
+	}
+	if link := readLink(t, filepath.Join(config, "nvim")); link != "../../dotfiles/nvim/.config/nvim" {
+		t.Errorf("Expected the nvim directory to be relinked, got %s", link)
+	}
+	if data, _ := os.ReadFile(filepath.Join(config, "fish", "config")); string(data) != "fish\n" {
+		t.Errorf("Expected the fish config through the link, got %q", data)
+	}
+
+	if _, _, err := stowPackages(opts, []string{"fish"}, stowOpUnlink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if link := readLink(t, config); link != "../dotfiles/nvim/.config" {
+		t.Errorf("Expected .config to be folded again, got %s", link)
+	}
+
+	if _, _, err := stowPackages(opts, []string{"nvim"}, stowOpUnlink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Lstat(config); !os.IsNotExist(err) {
+		t.Errorf("Expected .config to be removed, got %v", err)
+	}
+}
+
+func TestStowUnlinkKeepsUserDirectories(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"git/.config/git/ignore": "*.swp\n"})
+	os.MkdirAll(filepath.Join(target, ".config"), 0755)
+	os.WriteFile(filepath.Join(target, ".config", "user"), nil, 0644)
+	opts := stowOptions{dir: dir, target: target}
+
+	if _, _, err := stowPackages(opts, []string{"git"}, stowOpLink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if link := readLink(t, filepath.Join(target, ".config", "git")); link != "../../dotfiles/git/.config/git" {
+		t.Errorf("Expected the git directory inside the existing .config, got %s", link)
+	}
+
+	if _, _, err := stowPackages(opts, []string{"git"}, stowOpUnlink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(target, ".config", "user")); err != nil {
+		t.Errorf("Expected the user's files to be kept, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(target, ".config", "git")); !os.IsNotExist(err) {
+		t.Errorf("Expected the link to be removed, got %v", err)
+	}
+}
+
+func TestStowConflictsChangeNothing(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{
+		"zsh/.zshrc":     "zsh\n",
+		"zsh/.zprofile":  "profile\n",
+		"git/.gitconfig": "[core]\n",
+	})
+	os.WriteFile(filepath.Join(target, ".zshrc"), []byte("mine\n"), 0644)
+	os.Symlink("/elsewhere", filepath.Join(target, ".gitconfig"))
+
+	opts := stowOptions{dir: dir, target: target}
+	actions, conflicts, err := stowPackages(opts, []string{"git", "zsh"}, stowOpLink, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("Expected two conflicts, got %v", conflicts)
+	}
+	if !strings.Contains(conflicts[0].String(), "not owned") || !strings.Contains(conflicts[1].String(), "--adopt") {
+		t.Errorf("Expected the conflicts to explain themselves, got %v", conflicts)
+	}
+	if len(actions) == 0 {
+		t.Errorf("Expected the planned actions to be returned")
+	}
+	if _, err := os.Lstat(filepath.Join(target, ".zprofile")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be linked when there are conflicts, got %v", err)
+	}
+}
+
+func TestStowAdopt(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"zsh/.zshrc": "repo\n"})
+	os.WriteFile(filepath.Join(target, ".zshrc"), []byte("mine\n"), 0644)
+
+	opts := stowOptions{dir: dir, target: target, adopt: true}
+	if _, conflicts, err := stowPackages(opts, []string{"zsh"}, stowOpLink, false); err != nil || len(conflicts) > 0 {
+		t.Fatalf("Expected no error, got %v, %v", conflicts, err)
+	}
+	readLink(t, filepath.Join(target, ".zshrc"))
+	if data := string(mustRead(t, filepath.Join(dir, "zsh", ".zshrc"))); data != "mine\n" {
+		t.Errorf("Expected the existing file to be moved into the package, got %q", data)
+	}
+}
+
+func TestStowBackup(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"zsh/.zshrc": "repo\n"})
+	os.WriteFile(filepath.Join(target, ".zshrc"), []byte("mine\n"), 0644)
+	backup := filepath.Join(t.TempDir(), "backup")
+
+	opts := stowOptions{dir: dir, target: target, backup: backup}
+	if _, conflicts, err := stowPackages(opts, []string{"zsh"}, stowOpLink, false); err != nil || len(conflicts) > 0 {
+		t.Fatalf("Expected no error, got %v, %v", conflicts, err)
+	}
+	if data := string(mustRead(t, filepath.Join(backup, ".zshrc"))); data != "mine\n" {
+		t.Errorf("Expected the existing file in the backup, got %q", data)
+	}
+	readLink(t, filepath.Join(target, ".zshrc"))
+}
+
+func TestStowDryRun(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"zsh/.zshrc": "zsh\n"})
+
+	opts := stowOptions{dir: dir, target: target}
+	actions, _, err := stowPackages(opts, []string{"zsh"}, stowOpLink, true)
+	if err != nil || len(actions) != 1 || actions[0].kind != stowLink {
+		t.Fatalf("Expected one planned link, got %v, %v", actions, err)
+	}
+	if !strings.Contains(actions[0].String(), ".zshrc => ../dotfiles/zsh/.zshrc") {
+		t.Errorf("Expected the action to name the link, got %s", actions[0])
+	}
+	if _, err := os.Lstat(filepath.Join(target, ".zshrc")); !os.IsNotExist(err) {
+		t.Errorf("Expected a dry run to change nothing, got %v", err)
+	}
+}
+
+func TestStowIgnore(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{
+		"zsh/.zshrc":             "zsh\n",
+		"zsh/README.md":          "docs\n",
+		"zsh/.zshrc~":            "backup\n",
+		"zsh/.zsh_history":       "history\n",
+		"vim/.vimrc":             "vim\n",
+		"vim/README.md":          "docs\n",
+		"vim/notes.txt":          "notes\n",
+		"vim/.stow-local-ignore": "# only notes\nnotes\\.txt\n",
+	})
+
+	opts := stowOptions{dir: dir, target: target, ignore: []string{`_history`}}
+	if _, _, err := stowPackages(opts, []string{"zsh", "vim"}, stowOpLink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for name, linked := range map[string]bool{
+		".zshrc":             true,
+		"README.md":          true, // vim's ignore file replaces the defaults
+		".zshrc~":            false,
+		".zsh_history":       false,
+		".vimrc":             true,
+		"notes.txt":          false,
+		".stow-local-ignore": false,
+	} {
+		_, err := os.Lstat(filepath.Join(target, name))
+		if linked != (err == nil) {
+			t.Errorf("Expected %s linked=%v, got %v", name, linked, err)
+		}
+	}
+	if link := readLink(t, filepath.Join(target, "README.md")); link != "../dotfiles/vim/README.md" {
+		t.Errorf("Expected zsh's README to be ignored, got %s", link)
+	}
+}
+
+func TestStowNoFolding(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"nvim/.config/nvim/init.lua": "nvim\n"})
+
+	opts := stowOptions{dir: dir, target: target, noFolding: true}
+	if _, _, err := stowPackages(opts, []string{"nvim"}, stowOpLink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(target, ".config", "nvim")); err != nil || !info.IsDir() {
+		t.Errorf("Expected real directories, got %v, %v", info, err)
+	}
+	readLink(t, filepath.Join(target, ".config", "nvim", "init.lua"))
+}
+
+func TestStowRelink(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"zsh/.zshrc": "zsh\n", "zsh/.zprofile": "profile\n"})
+	opts := stowOptions{dir: dir, target: target}
+	if _, _, err := stowPackages(opts, []string{"zsh"}, stowOpLink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	os.Remove(filepath.Join(dir, "zsh", ".zprofile"))
+	os.WriteFile(filepath.Join(dir, "zsh", ".zshenv"), nil, 0644)
+	if _, _, err := stowPackages(opts, []string{"zsh"}, stowOpRelink, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	readLink(t, filepath.Join(target, ".zshenv"))
+	readLink(t, filepath.Join(target, ".zshrc"))
+	if _, err := os.Lstat(filepath.Join(target, ".zprofile")); !os.IsNotExist(err) {
+		t.Errorf("Expected the link to the removed file to be dropped, got %v", err)
+	}
+}
+
+func TestStowMissingPackage(t *testing.T) {
+	dir, target := newStowDirs(t, map[string]string{"zsh/.zshrc": "zsh\n"})
+	_, _, err := stowPackages(stowOptions{dir: dir, target: target}, []string{"tmux"}, stowOpLink, false)
+	if err == nil || !strings.Contains(err.Error(), "tmux") {
+		t.Errorf("Expected a missing package error, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// SudoSession keeps sudo's cached credentials valid while gomacdeploy runs.
+// Start asks for the password once and refreshes the credentials in the
+// background until Stop is called or its context is cancelled. Refreshing
+// never prompts: if it fails, for example because the cached credentials were
+// removed, the failure is reported, sudo commands run through its Runner fail,
+// and the next Elevate asks for the password again in the foreground.
+type SudoSession struct {
+	// Sudo is the sudo program.
+	Sudo string
+	// Askpass is a SUDO_ASKPASS helper that supplies the password instead of
+	// the terminal, for runs driven by a GUI or MDM wrapper.
+	Askpass string
+	// Interval is how often the credentials are refreshed. It must be shorter
+	// than sudo's timestamp_timeout, 5 minutes by default.
+	Interval time.Duration
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	mu      sync.RWMutex
+	err     error
+	cancel  context.CancelFunc
+	done    chan struct{}
+	startMu sync.Mutex
+	started bool
+}
+
+// newSudoSession returns a session for the real sudo. Without askpass, the
+// SUDO_ASKPASS environment variable is used, if set.
+func newSudoSession(askpass string) *SudoSession {
+	if askpass == "" {
+		askpass = os.Getenv("SUDO_ASKPASS")
+	}
+	return &SudoSession{
+		Sudo:     "sudo",
+		Askpass:  askpass,
+		Interval: time.Minute,
+		Stdin:    os.Stdin,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+	}
+}
+
+func (s *SudoSession) command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, s.Sudo, args...)
+	if s.Askpass != "" {
+		cmd.Env = append(os.Environ(), "SUDO_ASKPASS="+s.Askpass)
+	}
+	return cmd
+}
+
+// prompt asks for the password, through the askpass helper if there is one.
+func (s *SudoSession) prompt(ctx context.Context) error {
+	args := []string{"-v"}
+	if s.Askpass != "" {
+		args = []string{"-A", "-v"}
+	}
+	cmd := s.command(ctx, args...)
+	cmd.Stdin = s.Stdin
+	cmd.Stdout = s.Stdout
+	cmd.Stderr = s.Stderr
+	return cmd.Run()
+}
+
+// refresh extends the cached credentials without prompting.
+func (s *SudoSession) refresh(ctx context.Context) error {
+	return s.command(ctx, "-n", "-v").Run()
+}
+
+// Start asks for the password and starts refreshing the credentials. It
+// returns an error if sudo could not be validated.
+func (s *SudoSession) Start(ctx context.Context) error {
+	if err := s.prompt(ctx); err != nil {
+		return fmt.Errorf("validating sudo: %v", err)
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+	go s.keepAlive(ctx)
+	return nil
+}
+
+// Elevate makes sure the session has valid credentials before a step that
+// needs sudo. The first successful call starts the session; a call after a
+// refused password or a failed refresh asks for the password again, so one
+// mistyped password does not skip the rest of the privileged steps.
+func (s *SudoSession) Elevate(ctx context.Context) error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+
+	if !s.started {
+		fmt.Fprintln(s.Stdout, "Enter root password")
+		if err := s.Start(ctx); err != nil {
+			return err
+		}
+		s.started = true
+		return nil
+	}
+
+	if s.Err() == nil {
+		return nil
+	}
+	// Hold the lock while prompting so the refresher does not report the
+	// old failure over the new result.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(s.Stdout, "The sudo credentials expired. Enter your password to continue.")
+	if err := s.prompt(ctx); err != nil {
+		s.err = fmt.Errorf("validating sudo: %v", err)
+		return s.err
+	}
+	s.err = nil
+	return nil
+}
+
+// Stop stops refreshing the credentials and waits for the refresher to exit.
+func (s *SudoSession) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// Err returns why the session has no valid credentials, or nil if it has.
+func (s *SudoSession) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+func (s *SudoSession) keepAlive(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := s.refresh(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if err != nil {
+			err = fmt.Errorf("refreshing sudo: %v", err)
+			if s.err == nil {
+				fmt.Fprintf(s.Stderr, "Error %v; the next step that needs sudo will ask for the password again\n", err)
+			}
+		}
+		s.err = err
+		s.mu.Unlock()
+	}
+}
+
+// Runner wraps r so that its sudo commands wait while the session is asking
+// for the password again, and fail while it has no valid credentials instead
+// of prompting on their own. The commands do not block the refresher.
+func (s *SudoSession) Runner(r Runner) Runner {
+	return sudoRunner{Runner: r, session: s}
+}
+
+type sudoRunner struct {
+	Runner
+	session *SudoSession
+}
+
+func (s sudoRunner) Run(name string, args ...string) error {
+	if name != "sudo" {
+		return s.Runner.Run(name, args...)
+	}
+	// Only the check holds the lock: the command itself may run for a long
+	// time, and the refresher must keep the credentials valid meanwhile.
+	if err := s.session.Err(); err != nil {
+		return fmt.Errorf("no sudo credentials: %v", err)
+	}
+	return s.Runner.Run(name, args...)
+}
+
+func (s sudoRunner) Output(name string, args ...string) (string, error) {
+	if name != "sudo" {
+		return s.Runner.Output(name, args...)
+	}
+	// Only the check holds the lock: the command itself may run for a long
+	// time, and the refresher must keep the credentials valid meanwhile.
+	if err := s.session.Err(); err != nil {
+		return "", fmt.Errorf("no sudo credentials: %v", err)
+	}
+	return s.Runner.Output(name, args...)
+}
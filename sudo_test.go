@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSudo is a sudo replacement that logs its arguments and SUDO_ASKPASS.
+// Refreshes fail while the expired file exists, until a password prompt
+// succeeds, and password prompts fail while the refused file exists.
+type fakeSudo struct {
+	path, log, expired, refused string
+}
+
+func newFakeSudo(t *testing.T) fakeSudo {
+	dir := t.TempDir()
+	f := fakeSudo{
+		path:    filepath.Join(dir, "sudo"),
+		log:     filepath.Join(dir, "log"),
+		expired: filepath.Join(dir, "expired"),
+		refused: filepath.Join(dir, "refused"),
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$* askpass=$SUDO_ASKPASS" >> %q
+if [ "$1" = "-n" ]; then
+	[ ! -e %q ]
+	exit
+fi
+[ -e %q ] && exit 1
+rm -f %q
+`, f.log, f.expired, f.refused, f.expired)
+	if err := os.WriteFile(f.path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f fakeSudo) create(t *testing.T, path string) {
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// calls returns the logged sudo invocations.
+func (f fakeSudo) calls(t *testing.T) []string {
+	data, err := os.ReadFile(f.log)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func newTestSudoSession(sudo string) *SudoSession {
+	return &SudoSession{Sudo: sudo, Interval: 10 * time.Millisecond, Stdin: strings.NewReader(""), Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}}
+}
+
+// waitFor polls until cond holds or a few seconds pass.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("Timed out waiting")
+}
+
+func TestSudoSessionRefreshes(t *testing.T) {
+	sudo := newFakeSudo(t)
+	s := newTestSudoSession(sudo.path)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	waitFor(t, func() bool { return len(sudo.calls(t)) >= 3 })
+	s.Stop()
+
+	calls := sudo.calls(t)
+	if calls[0] != "-v askpass=" || calls[1] != "-n -v askpass=" {
+		t.Errorf("Expected a prompt and then refreshes, got %v", calls)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if after := sudo.calls(t); len(after) != len(calls) {
+		t.Errorf("Expected no refreshes after Stop, got %v", after[len(calls):])
+	}
+}
+
+func TestSudoSessionRefreshFails(t *testing.T) {
+	sudo := newFakeSudo(t)
+	s := newTestSudoSession(sudo.path)
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Stop()
+	sudo.create(t, sudo.expired)
+	waitFor(t, func() bool { return s.Err() != nil })
+
+	// The refresher reports the failure instead of prompting in the
+	// background.
+	waitFor(t, func() bool { return len(sudo.calls(t)) > 4 })
+	for _, call := range sudo.calls(t)[1:] {
+		if call != "-n -v askpass=" {
+			t.Errorf("Expected only non-interactive refreshes, got %v", sudo.calls(t))
+			break
+		}
+	}
+	if stderr := s.Stderr.(*bytes.Buffer).String(); strings.Count(stderr, "Error refreshing sudo") != 1 {
+		t.Errorf("Expected the failure to be reported once, got %q", stderr)
+	}
+
+	// Without credentials, sudo commands fail instead of prompting.
+	r := newFakeRunner(nil)
+	if err := s.Runner(r).Run("sudo", "softwareupdate", "-l"); err == nil || !strings.Contains(err.Error(), "no sudo credentials") {
+		t.Errorf("Expected the sudo command to be refused, got %v", err)
+	}
+	if _, err := s.Runner(r).Output("brew", "--version"); err != nil {
+		t.Errorf("Expected other commands to run, got %v", err)
+	}
+	if len(r.calls) != 1 || r.calls[0] != "brew --version" {
+		t.Errorf("Expected only brew to run, got %v", r.calls)
+	}
+
+	// The next step that needs sudo asks for the password in the foreground.
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected the session to recover, got %v", err)
+	}
+	if calls := sudo.calls(t); calls[len(calls)-1] != "-v askpass=" {
+		t.Errorf("Expected a password prompt, got %v", calls)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Expected valid credentials, got %v", err)
+	}
+}
+
+// slowRunner is a Runner whose commands take a while.
+type slowRunner struct {
+	*fakeRunner
+	delay time.Duration
+}
+
+func (s slowRunner) Run(name string, args ...string) error {
+	time.Sleep(s.delay)
+	return s.fakeRunner.Run(name, args...)
+}
+
+func TestSudoSessionRefreshesDuringLongCommands(t *testing.T) {
+	sudo := newFakeSudo(t)
+	s := newTestSudoSession(sudo.path)
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Stop()
+	waitFor(t, func() bool { return len(sudo.calls(t)) >= 2 })
+
+	refreshes := func() int {
+		n := 0
+		for _, call := range sudo.calls(t) {
+			if call == "-n -v askpass=" {
+				n++
+			}
+		}
+		return n
+	}
+	before := refreshes()
+	r := slowRunner{fakeRunner: newFakeRunner(nil), delay: 10 * s.Interval}
+	if err := s.Runner(r).Run("sudo", "softwareupdate", "-i", "-a"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if after := refreshes(); after-before < 3 {
+		t.Errorf("Expected the credentials to be refreshed while the command ran, got %d refreshes", after-before)
+	}
+}
+
+func TestSudoSessionRepromptFails(t *testing.T) {
+	sudo := newFakeSudo(t)
+	s := newTestSudoSession(sudo.path)
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer s.Stop()
+	sudo.create(t, sudo.refused)
+	sudo.create(t, sudo.expired)
+	waitFor(t, func() bool { return s.Err() != nil })
+
+	if err := s.Elevate(context.Background()); err == nil {
+		t.Fatal("Expected a refused password to fail")
+	}
+
+	// Once the password is accepted again, the session recovers.
+	os.Remove(sudo.refused)
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected the session to recover, got %v", err)
+	}
+	waitFor(t, func() bool { return s.Err() == nil })
+}
+
+func TestSudoSessionStartFails(t *testing.T) {
+	sudo := newFakeSudo(t)
+	sudo.create(t, sudo.refused)
+	s := newTestSudoSession(sudo.path)
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatal("Expected Start to fail when the password is refused")
+	}
+	s.Stop()
+	if calls := sudo.calls(t); len(calls) != 1 {
+		t.Errorf("Expected no refreshes, got %v", calls)
+	}
+}
+
+func TestSudoSessionAskpass(t *testing.T) {
+	sudo := newFakeSudo(t)
+	s := newTestSudoSession(sudo.path)
+	s.Askpass = "/usr/local/libexec/askpass"
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	s.Stop()
+
+	if calls := sudo.calls(t); calls[0] != "-A -v askpass=/usr/local/libexec/askpass" {
+		t.Errorf("Expected sudo to use the askpass helper, got %v", calls)
+	}
+}
+
+func TestSudoSessionContext(t *testing.T) {
+	sudo := newFakeSudo(t)
+	s := newTestSudoSession(sudo.path)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cancel()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the refresher to exit when the context is cancelled")
+	}
+}
+
+func TestSudoSessionElevateRetries(t *testing.T) {
+	sudo := newFakeSudo(t)
+	sudo.create(t, sudo.refused)
+	s := newTestSudoSession(sudo.path)
+	defer s.Stop()
+	if err := s.Elevate(context.Background()); err == nil {
+		t.Fatal("Expected a refused password to fail")
+	}
+
+	// A later step asks again rather than reusing the failure.
+	os.Remove(sudo.refused)
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if err := s.Elevate(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	prompts := 0
+	for _, call := range sudo.calls(t) {
+		if call == "-v askpass=" {
+			prompts++
+		}
+	}
+	if prompts != 2 {
+		t.Errorf("Expected two prompts, got %v", sudo.calls(t))
+	}
+}
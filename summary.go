@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// summaryReport collects what the steps want to tell the user at the end of
+// a deployment, so it is not lost in the output of the steps that follow.
+type summaryReport struct {
+	sections []summarySection
+}
+
+type summarySection struct {
+	title string
+	lines []string
+}
+
+// add appends a section to the report. Sections without lines are left out.
+func (s *summaryReport) add(title string, lines ...string) {
+	if len(lines) == 0 {
+		return
+	}
+	s.sections = append(s.sections, summarySection{title: title, lines: lines})
+}
+
+func (s *summaryReport) print(w io.Writer) {
+	if len(s.sections) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "SUMMARY")
+	for _, section := range s.sections {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s:\n", section.title)
+		for _, line := range section.lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// addAppStore reports the App Store apps that were not installed and the apps
+// that are still outdated.
+func (s *summaryReport) addAppStore(results []appStoreResult, outdated []masListing) {
+	var problems []string
+	for _, result := range results {
+		if result.Status != appStoreInstalled && result.Status != appStoreAlreadyPresent {
+			problems = append(problems, result.String())
+		}
+	}
+	s.add("App Store apps not installed", problems...)
+
+	var lines []string
+	for _, listing := range outdated {
+		lines = append(lines, fmt.Sprintf("%s (%s) %s", listing.Name, listing.ID, listing.Version))
+	}
+	s.add("Outdated App Store apps", lines...)
+}
+
+// addRosetta reports what the Rosetta step did, whatever the outcome, so a
+// skipped install is as visible as a failed one.
+func (s *summaryReport) addRosetta(result rosettaResult) {
+	line := result.Status
+	if result.Detail != "" {
+		line += " (" + result.Detail + ")"
+	}
+	s.add("Rosetta", line)
+}
+
+// addBrewOutdated reports the formulae and casks Homebrew considers outdated.
+func (s *summaryReport) addBrewOutdated(r Runner) {
+	out, err := r.Output("brew", "outdated", "--verbose")
+	if err != nil {
+		s.add("Outdated Homebrew packages", fmt.Sprintf("could not run brew outdated: %v", err))
+		return
+	}
+
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	s.add("Outdated Homebrew packages", lines...)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSummaryReport(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"brew outdated --verbose": {output: "git (2.46.0) < 2.47.0\nwarp (0.2024.10) != 0.2024.11\n"},
+	})
+
+	report := &summaryReport{}
+	report.add("Empty section")
+	report.addAppStore(
+		[]appStoreResult{
+			{App: AppStoreApp{ID: "409201541"}, Status: appStoreInstalled},
+			{App: AppStoreApp{Name: "Xcode"}, Status: appStoreNotSignedIn},
+		},
+		[]masListing{{ID: "497799835", Name: "Xcode", Version: "15.4 -> 16.0"}},
+	)
+	report.addBrewOutdated(r)
+
+	var buf bytes.Buffer
+	report.print(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"App Store apps not installed:\n  Xcode: skipped, not signed in\n",
+		"Outdated App Store apps:\n  Xcode (497799835) 15.4 -> 16.0\n",
+		"Outdated Homebrew packages:\n  git (2.46.0) < 2.47.0\n  warp (0.2024.10) != 0.2024.11\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Empty section") || strings.Contains(out, "409201541") {
+		t.Errorf("Expected empty sections and installed apps to be left out, got:\n%s", out)
+	}
+}
+
+func TestSummaryReportRosetta(t *testing.T) {
+	for _, tc := range []struct {
+		result rosettaResult
+		want   string
+	}{
+		{rosettaResult{Status: rosettaInstalled}, "Rosetta:\n  installed\n"},
+		{rosettaResult{Status: rosettaAlreadyPresent}, "Rosetta:\n  already present\n"},
+		{rosettaResult{Status: rosettaSkipped, Detail: "not an Apple silicon Mac"}, "Rosetta:\n  skipped (not an Apple silicon Mac)\n"},
+		{rosettaResult{Status: rosettaFailed, Detail: "exit status 1"}, "Rosetta:\n  failed (exit status 1)\n"},
+	} {
+		report := &summaryReport{}
+		report.addRosetta(tc.result)
+
+		var buf bytes.Buffer
+		report.print(&buf)
+		if !strings.Contains(buf.String(), tc.want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", tc.want, buf.String())
+		}
+	}
+}
+
+func TestSummaryReportBrewOutdatedError(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{
+		"brew outdated --verbose": {err: errors.New("exit status 1")},
+	})
+
+	report := &summaryReport{}
+	report.addBrewOutdated(r)
+	if len(report.sections) != 1 || !strings.Contains(report.sections[0].lines[0], "could not run brew outdated") {
+		t.Errorf("Expected the failure to be reported, got %+v", report.sections)
+	}
+}
+
+func TestSummaryReportEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	(&summaryReport{}).print(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("Expected an empty report to print nothing, got %q", buf.String())
+	}
+}
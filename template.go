@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// varEnvPrefix is the prefix of environment variables that define template
+// variables, e.g. GOMACDEPLOY_VAR_workEmail=me@example.com.
+const varEnvPrefix = "GOMACDEPLOY_VAR_"
+
+// varFlag collects repeated --var key=value flags.
+type varFlag map[string]string
+
+func (v varFlag) String() string {
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key+"="+v[key])
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (v varFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	v[key] = val
+	return nil
+}
+
+// nativeArch returns the architecture of the machine rather than of the
+// running binary, so an amd64 build running under Rosetta still reports arm64
+// on Apple silicon.
+var nativeArch = sync.OnceValue(func() string {
+	if appleSilicon(execRunner{}) {
+		return "arm64"
+	}
+	return runtime.GOARCH
+})
+
+// brewPrefix returns the default Homebrew prefix for the machine's
+// architecture.
+func brewPrefix() string {
+	if nativeArch() == "arm64" {
+		return "/opt/homebrew"
+	}
+	return "/usr/local"
+}
+
+// builtinVars returns the variables every config can use without defining
+// them.
+func builtinVars() map[string]string {
+	vars := map[string]string{
+		"arch":       nativeArch(),
+		"brewPrefix": brewPrefix(),
+	}
+
+	if u, err := user.Current(); err == nil {
+		vars["user"] = u.Username
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		vars["home"] = home
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		vars["hostname"] = strings.TrimSuffix(hostname, ".local")
+	}
+
+	return vars
+}
+
+// templateVars assembles the variables config values are rendered with. Later
+// sources win: built-ins, then the config's vars section, then
+// GOMACDEPLOY_VAR_* environment variables, then --var flags. Values in the
+// vars section may themselves refer to built-in variables.
+func templateVars(config *Config, overrides map[string]string) (map[string]string, error) {
+	vars := builtinVars()
+
+	names := make([]string, 0, len(config.Vars))
+	for name := range config.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := renderString(config.Vars[name], vars)
+		if err != nil {
+			return nil, fmt.Errorf("vars.%s: %w", name, err)
+		}
+		vars[name] = value
+	}
+
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, varEnvPrefix) {
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(env, varEnvPrefix), "=")
+		if name != "" {
+			vars[name] = value
+		}
+	}
+
+	for name, value := range overrides {
+		vars[name] = value
+	}
+
+	return vars, nil
+}
+
+// renderString executes s as a text/template with vars as its data. Strings
+// without template actions are returned unchanged. Referring to an undefined
+// variable is an error.
+func renderString(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	tmpl, err := template.New("value").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// renderConfig renders every string in config in place. Fields tagged
+// render:"deferred" are left for their step to render.
+func renderConfig(config *Config, vars map[string]string) error {
+	return renderValue(reflect.ValueOf(config).Elem(), "config", vars)
+}
+
+func renderValue(v reflect.Value, path string, vars map[string]string) error {
+	switch v.Kind() {
+	case reflect.String:
+		rendered, err := renderString(v.String(), vars)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetString(rendered)
+
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return renderValue(v.Elem(), path, vars)
+		}
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := renderValue(elem, path, vars); err != nil {
+			return err
+		}
+		v.Set(elem)
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).IsExported() || t.Field(i).Tag.Get("render") == "deferred" {
+				continue
+			}
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if err := renderValue(v.Field(i), joinPath(path, name), vars); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := renderValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), vars); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := renderValue(elem, joinPath(path, fmt.Sprint(key)), vars); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestRenderString(t *testing.T) {
+	vars := map[string]string{"home": "/Users/test", "user": "test"}
+
+	got, err := renderString("{{ .home }}/Applications/{{ .user }}.app", vars)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got != "/Users/test/Applications/test.app" {
+		t.Errorf("Unexpected render result %q", got)
+	}
+
+	if _, err := renderString("{{ .missing }}", vars); err == nil {
+		t.Error("Expected undefined variable error, got nil")
+	}
+
+	if got, _ := renderString("defaults write -g Key -bool true", vars); got != "defaults write -g Key -bool true" {
+		t.Errorf("Expected plain string to be unchanged, got %q", got)
+	}
+}
+
+func TestTemplateVarsPrecedence(t *testing.T) {
+	t.Setenv(varEnvPrefix+"team", "platform")
+	t.Setenv(varEnvPrefix+"email", "env@example.com")
+
+	config := &Config{Vars: map[string]string{
+		"email":   "config@example.com",
+		"team":    "config",
+		"workDir": "{{ .home }}/work",
+	}}
+
+	vars, err := templateVars(config, map[string]string{"email": "flag@example.com"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if vars["email"] != "flag@example.com" {
+		t.Errorf("Expected --var to win, got %q", vars["email"])
+	}
+	if vars["team"] != "platform" {
+		t.Errorf("Expected environment to override config, got %q", vars["team"])
+	}
+	if vars["workDir"] != vars["home"]+"/work" {
+		t.Errorf("Expected config var to use built-ins, got %q", vars["workDir"])
+	}
+	for _, name := range []string{"user", "home", "hostname", "arch", "brewPrefix"} {
+		if _, ok := vars[name]; !ok {
+			t.Errorf("Expected built-in variable %q", name)
+		}
+	}
+}
+
+func TestRenderConfig(t *testing.T) {
+	config := &Config{
+		Dock:            DockConfig{Add: []string{"{{ .home }}/Applications/Tool.app"}},
+		DefaultSettings: []string{"defaults write com.apple.screencapture location {{ .home }}/Screenshots"},
+	}
+
+	if err := renderConfig(config, map[string]string{"home": "/Users/test"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.Dock.Add[0] != "/Users/test/Applications/Tool.app" {
+		t.Errorf("Unexpected dock.add %q", config.Dock.Add[0])
+	}
+	if !strings.HasSuffix(config.DefaultSettings[0], "/Users/test/Screenshots") {
+		t.Errorf("Unexpected defaultSettings %q", config.DefaultSettings[0])
+	}
+
+	err := renderConfig(&Config{Casks: []string{"{{ .nope }}"}}, map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "casks[0]") {
+		t.Errorf("Expected error naming casks[0], got %v", err)
+	}
+}
+
+func TestConfigFlagsLoadRendersVars(t *testing.T) {
+	path := writeTempConfig(t, "deploy.yml", `
+vars:
+  gitEmail: "{{ .user }}@example.com"
+dock:
+  add:
+    - "/Applications/{{ .app }}.app"
+defaultSettings:
+  - "git config --global user.email {{ .gitEmail }}"
+`)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFlags := addConfigFlags(fs)
+	if err := fs.Parse([]string{"--config", path, "--var", "app=Warp", "--var", "gitEmail=me@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, vars, err := configFlags.load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.Dock.Add[0] != "/Applications/Warp.app" {
+		t.Errorf("Unexpected dock.add %q", config.Dock.Add[0])
+	}
+	if config.DefaultSettings[0] != "git config --global user.email me@example.com" {
+		t.Errorf("Unexpected defaultSettings %q", config.DefaultSettings[0])
+	}
+	if vars["gitEmail"] != "me@example.com" {
+		t.Errorf("Expected the variables to be returned, got %v", vars)
+	}
+}
+
+func TestValidateConfigTemplates(t *testing.T) {
+	problems := validateConfig([]byte("casks:\n  - \"{{ .home }/X\"\n"), formatYAML)
+	if len(problems) != 1 || problems[0].Line != 2 {
+		t.Errorf("Expected one template problem on line 2, got %v", problems)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemRoot is prepended to the system files the Touch ID step reads and
+// writes.
+var systemRoot = "/"
+
+// The PAM configuration for sudo. Since macOS 14, /etc/pam.d/sudo includes
+// sudo_local, which survives macOS updates.
+const (
+	pamSudo      = "etc/pam.d/sudo"
+	pamSudoLocal = "etc/pam.d/sudo_local"
+)
+
+// touchIDBlock names gomacdeploy's block in sudo_local.
+const touchIDBlock = "touch-id"
+
+// TouchIDConfig lets sudo accept Touch ID. Setting Enabled to false removes
+// gomacdeploy's lines again. Reattach adds pam_reattach, which Touch ID needs
+// inside tmux and screen.
+type TouchIDConfig struct {
+	Enabled  *bool `yaml:"enabled,omitempty" json:"enabled,omitempty" toml:"enabled,omitempty"`
+	Reattach bool  `yaml:"reattach,omitempty" json:"reattach,omitempty" toml:"reattach,omitempty"`
+}
+
+func (t TouchIDConfig) enabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// pamReattachModule is where the pam-reattach formula installs its module.
+func pamReattachModule() string {
+	return filepath.Join(brewPrefix(), "lib", "pam", "pam_reattach.so")
+}
+
+// touchIDLines returns the PAM lines for config. pam_reattach has to come
+// before pam_tid.
+func touchIDLines(config TouchIDConfig) []string {
+	if !config.enabled() {
+		return nil
+	}
+	var lines []string
+	if config.Reattach {
+		lines = append(lines, fmt.Sprintf("auth       optional       %s ignore_ssh", pamReattachModule()))
+	}
+	return append(lines, "auth       sufficient     pam_tid.so")
+}
+
+// pamTidActive reports whether content has an uncommented pam_tid line.
+func pamTidActive(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#") && strings.Contains(line, "pam_tid.so") {
+			return true
+		}
+	}
+	return false
+}
+
+// planTouchID returns the current and wanted contents of sudo_local. They are
+// the same when there is nothing to do, including when Touch ID was enabled
+// by hand outside gomacdeploy's block.
+func planTouchID(config TouchIDConfig) (string, string, error) {
+	sudo, err := os.ReadFile(filepath.Join(systemRoot, pamSudo))
+	if err != nil {
+		return "", "", err
+	}
+	if !strings.Contains(string(sudo), "sudo_local") {
+		return "", "", fmt.Errorf("/%s does not include sudo_local; Touch ID for sudo needs macOS 14 or later", pamSudo)
+	}
+
+	data, err := os.ReadFile(filepath.Join(systemRoot, pamSudoLocal))
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	current := string(data)
+	if config.enabled() && pamTidActive(replaceManagedBlock(current, touchIDBlock, nil)) {
+		return current, current, nil
+	}
+	return current, replaceManagedBlock(current, touchIDBlock, touchIDLines(config)), nil
+}
+
+// touchIDPending reports whether configureTouchID has anything to change.
+// Errors count as pending so the step runs and reports them.
+func touchIDPending(config TouchIDConfig) bool {
+	current, updated, err := planTouchID(config)
+	return err != nil || current != updated
+}
+
+// configureTouchID enables or disables Touch ID for sudo in sudo_local,
+// installing pam_reattach first if it is wanted.
+func configureTouchID(r Runner, config TouchIDConfig) error {
+	clearScreen()
+	fmt.Println("Configuring Touch ID for sudo...")
+	if config.enabled() && config.Reattach {
+		if err := installPamReattach(r); err != nil {
+			return err
+		}
+	}
+
+	current, updated, err := planTouchID(config)
+	if err != nil {
+		return err
+	}
+	if current == updated {
+		fmt.Println("Touch ID for sudo is already configured.")
+		return nil
+	}
+	if err := installSystemFile(r, filepath.Join(systemRoot, pamSudoLocal), updated, "444"); err != nil {
+		return fmt.Errorf("writing /%s: %v", pamSudoLocal, err)
+	}
+	if config.enabled() {
+		fmt.Println("Enabled Touch ID for sudo.")
+	} else {
+		fmt.Println("Disabled Touch ID for sudo.")
+	}
+	return nil
+}
+
+// installPamReattach installs the pam-reattach formula unless its module is
+// already there.
+func installPamReattach(r Runner) error {
+	module := filepath.Join(systemRoot, pamReattachModule())
+	if _, err := os.Stat(module); err == nil {
+		return nil
+	}
+	if err := r.Run("brew", "install", "pam-reattach"); err != nil {
+		return fmt.Errorf("installing pam-reattach: %v", err)
+	}
+	if _, err := os.Stat(module); err != nil {
+		return fmt.Errorf("pam-reattach is installed but %s is missing", pamReattachModule())
+	}
+	return nil
+}
+
+// installSystemFile replaces a root-owned file with content through sudo
+// install.
+func installSystemFile(r Runner, path, content, mode string) error {
+	file, err := os.CreateTemp("", "gomacdeploy-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return r.Run("sudo", "install", "-m", mode, "-o", "root", "-g", "wheel", file.Name(), path)
+}
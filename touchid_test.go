@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sudoLocalTemplate is /etc/pam.d/sudo_local.template from macOS 14.
+const sudoLocalTemplate = `# sudo_local: local config file which survives system update and is included for sudo
+# uncomment following line to enable Touch ID for sudo
+#auth       sufficient     pam_tid.so
+`
+
+// pamSudoSonoma is /etc/pam.d/sudo from macOS 14.
+const pamSudoSonoma = `# sudo: auth account password session
+auth       include        sudo_local
+auth       sufficient     pam_smartcard.so
+auth       required       pam_opendirectory.so
+account    required       pam_permit.so
+password   required       pam_deny.so
+session    required       pam_permit.so
+`
+
+// newSystemRoot points systemRoot at a temporary directory holding files.
+func newSystemRoot(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := systemRoot
+	systemRoot = root
+	t.Cleanup(func() { systemRoot = old })
+	return root
+}
+
+// installRunner carries out sudo install by copying the file, and creates
+// the pam_reattach module when the formula is installed.
+type installRunner struct {
+	*fakeRunner
+	t *testing.T
+}
+
+func (i *installRunner) Run(name string, args ...string) error {
+	if err := i.fakeRunner.Run(name, args...); err != nil {
+		return err
+	}
+	switch {
+	case name == "sudo" && args[0] == "install":
+		src, dst := args[len(args)-2], args[len(args)-1]
+		return os.WriteFile(dst, mustRead(i.t, src), 0444)
+	case name == "brew" && args[len(args)-1] == "pam-reattach":
+		module := filepath.Join(systemRoot, pamReattachModule())
+		if err := os.MkdirAll(filepath.Dir(module), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(module, nil, 0644)
+	}
+	return nil
+}
+
+func TestConfigureTouchID(t *testing.T) {
+	root := newSystemRoot(t, map[string]string{pamSudo: pamSudoSonoma, pamSudoLocal: sudoLocalTemplate})
+	path := filepath.Join(root, pamSudoLocal)
+	r := &installRunner{fakeRunner: newFakeRunner(nil), t: t}
+
+	config := TouchIDConfig{Reattach: true}
+	if err := configureTouchID(r, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	got := string(mustRead(t, path))
+	reattach := strings.Index(got, pamReattachModule()+" ignore_ssh")
+	tid := strings.Index(got, "\nauth       sufficient     pam_tid.so\n")
+	if !strings.HasPrefix(got, sudoLocalTemplate) || reattach < 0 || tid < reattach {
+		t.Errorf("Expected pam_reattach and pam_tid after the template, got:\n%s", got)
+	}
+	if !r.called("brew install pam-reattach") {
+		t.Errorf("Expected pam-reattach to be installed, calls: %v", r.calls)
+	}
+
+	// Running it again changes nothing and needs no sudo.
+	r.calls = nil
+	if touchIDPending(config) {
+		t.Error("Expected nothing to be pending")
+	}
+	if err := configureTouchID(r, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("Expected nothing to run, got %v", r.calls)
+	}
+
+	// Disabling restores the template.
+	off := false
+	if err := configureTouchID(r, TouchIDConfig{Enabled: &off}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := string(mustRead(t, path)); got != sudoLocalTemplate {
+		t.Errorf("Expected the template back, got:\n%s", got)
+	}
+}
+
+func TestConfigureTouchIDEnabledByHand(t *testing.T) {
+	enabled := strings.Replace(sudoLocalTemplate, "#auth", "auth", 1)
+	newSystemRoot(t, map[string]string{pamSudo: pamSudoSonoma, pamSudoLocal: enabled})
+	r := &installRunner{fakeRunner: newFakeRunner(nil), t: t}
+
+	if touchIDPending(TouchIDConfig{}) {
+		t.Error("Expected a hand-enabled pam_tid to count as configured")
+	}
+	if err := configureTouchID(r, TouchIDConfig{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("Expected nothing to run, got %v", r.calls)
+	}
+}
+
+func TestConfigureTouchIDMissingSudoLocal(t *testing.T) {
+	root := newSystemRoot(t, map[string]string{pamSudo: pamSudoSonoma})
+	r := &installRunner{fakeRunner: newFakeRunner(nil), t: t}
+	if err := configureTouchID(r, TouchIDConfig{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := string(mustRead(t, filepath.Join(root, pamSudoLocal))); !strings.Contains(got, "pam_tid.so") {
+		t.Errorf("Expected sudo_local to be created, got:\n%s", got)
+	}
+
+	// Before macOS 14, sudo does not read sudo_local.
+	newSystemRoot(t, map[string]string{pamSudo: "auth       sufficient     pam_smartcard.so\n"})
+	if err := configureTouchID(r, TouchIDConfig{}); err == nil || !strings.Contains(err.Error(), "macOS 14") {
+		t.Errorf("Expected an unsupported macOS error, got %v", err)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configProblem is a single issue found while validating a config file.
+// Warnings, such as deprecated keys, do not stop the config from loading.
+type configProblem struct {
+	Line    int
+	Column  int
+	Message string
+	Warning bool
+}
+
+func (p configProblem) String() string {
+	message := p.Message
+	if p.Warning {
+		message = "warning: " + message
+	}
+	if p.Line == 0 {
+		return message
+	}
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, message)
+}
+
+// in formats the problem as found in the config at location.
+func (p configProblem) in(location string) string {
+	if p.Line == 0 {
+		return location + ": " + p.String()
+	}
+	return location + ":" + p.String()
+}
+
+// listedAt describes where node was first listed, or nothing if its position
+// is not known.
+func listedAt(format string, node *yaml.Node) string {
+	if node.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf(format, node.Line)
+}
+
+// validateCommand implements `gomacdeploy validate`. It prints every problem
+// found in the config and exits non-zero if there are any.
+func validateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFlags := addConfigFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		configFlags.location = fs.Arg(0)
+	}
+
+	path, err := configFlags.resolve()
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	format, err := detectConfigFormat(path, configFlags.format)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+
+	errorCount := 0
+	for _, problem := range validateConfig(data, format) {
+		fmt.Println(problem.in(configFlags.location))
+		if !problem.Warning {
+			errorCount++
+		}
+	}
+	if errorCount > 0 {
+		fmt.Printf("%d problem(s) found.\n", errorCount)
+		return 1
+	}
+
+	fmt.Printf("%s: OK\n", configFlags.location)
+	return 0
+}
+
+// validateConfig checks a config against the Config schema and reports
+// deprecated forms, unknown keys, values of the wrong shape and duplicate
+// packages, each with the position it was found at, along with the problems
+// the sections' own checks find. Those live next to their types. Older
+// config versions are migrated before they are checked.
+func validateConfig(data []byte, format string) []configProblem {
+	root, problems, _, err := parseAndMigrateConfig(data, format)
+	if err != nil {
+		return []configProblem{syntaxProblem(err)}
+	}
+	if root == nil {
+		return nil
+	}
+
+	checkNode(root, reflect.TypeOf(Config{}), "config", &problems)
+	checkDuplicatePackages(root, &problems)
+	checkDockReplace(root, &problems)
+	checkRuntimes(root, &problems)
+	checkGit(root, &problems)
+	checkSSHHosts(root, &problems)
+	checkSoftwareUpdate(root, &problems)
+	checkHomebrew(root, &problems)
+	checkHost(root, &problems)
+	checkTemplates(root, &problems)
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+	return problems
+}
+
+var (
+	linePattern   = regexp.MustCompile(`line (\d+)`)
+	columnPattern = regexp.MustCompile(`column (\d+)`)
+)
+
+// syntaxProblem converts a parse error into a problem, recovering the
+// position from the error where the parser provides one.
+func syntaxProblem(err error) configProblem {
+	problem := configProblem{Line: 1, Column: 1, Message: err.Error()}
+
+	var tomlErr toml.ParseError
+	if errors.As(err, &tomlErr) {
+		problem.Line, problem.Column = tomlErr.Position.Line, tomlErr.Position.Col
+		problem.Message = tomlErr.Message
+		return problem
+	}
+
+	if m := linePattern.FindStringSubmatch(err.Error()); m != nil {
+		problem.Line, _ = strconv.Atoi(m[1])
+	}
+	if m := columnPattern.FindStringSubmatch(err.Error()); m != nil {
+		problem.Column, _ = strconv.Atoi(m[1])
+	}
+	return problem
+}
+
+func newProblem(node *yaml.Node, format string, args ...interface{}) configProblem {
+	return configProblem{Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkNode walks node alongside the Go type it will be decoded into.
+func checkNode(node *yaml.Node, t reflect.Type, path string, problems *[]configProblem) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	// Types with their own decoding rules are checked by decoding them.
+	if reflect.PtrTo(t).Implements(yamlUnmarshalerType) {
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			*problems = append(*problems, newProblem(node, "%s: %v", path, err))
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			*problems = append(*problems, newProblem(node, "%s must be a mapping", path))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				*problems = append(*problems, unknownKeyProblem(key, path, fields))
+				continue
+			}
+			checkNode(value, field.Type, joinPath(path, key.Value), problems)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			*problems = append(*problems, newProblem(node, "%s must be a list", path))
+			return
+		}
+		for i, item := range node.Content {
+			checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			*problems = append(*problems, newProblem(node, "%s must be a mapping", path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			checkNode(value, t.Elem(), joinPath(path, key.Value), problems)
+		}
+
+	case reflect.Interface:
+		return
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			*problems = append(*problems, newProblem(node, "%s must be a single value", path))
+			return
+		}
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			*problems = append(*problems, newProblem(node, "%s: expected %s, got %q", path, t.Kind(), node.Value))
+		}
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "config" {
+		return key
+	}
+	return path + "." + key
+}
+
+// yamlFields maps the YAML key of every field of a struct type to the field.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func unknownKeyProblem(key *yaml.Node, path string, fields map[string]reflect.StructField) configProblem {
+	best, bestDistance := "", 3
+	for name := range fields {
+		if d := editDistance(strings.ToLower(key.Value), strings.ToLower(name)); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+
+	if best != "" {
+		return newProblem(key, "unknown key %q in %s (did you mean %q?)", key.Value, path, best)
+	}
+	return newProblem(key, "unknown key %q in %s", key.Value, path)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// mappingValue returns the value stored under key in a mapping node.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// scalarItems returns the scalar entries of the list stored under the dotted
+// key path. For mapping entries, such as App Store apps, the id (or failing
+// that the name) stands in for the entry.
+func scalarItems(root *yaml.Node, path string) []*yaml.Node {
+	list := root
+	for _, key := range strings.Split(path, ".") {
+		if list = mappingValue(list, key); list == nil {
+			return nil
+		}
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var items []*yaml.Node
+	for _, item := range list.Content {
+		switch item.Kind {
+		case yaml.ScalarNode:
+			items = append(items, item)
+		case yaml.MappingNode:
+			if id := mappingValue(item, "id"); id != nil {
+				items = append(items, id)
+			} else if name := mappingValue(item, "name"); name != nil {
+				items = append(items, name)
+			}
+		}
+	}
+	return items
+}
+
+// checkDuplicatePackages reports packages listed twice in the same list and
+// names that appear both as a formula and as a cask.
+func checkDuplicatePackages(root *yaml.Node, problems *[]configProblem) {
+	lists := []struct{ key, kind string }{
+		{"formulae", "formula"},
+		{"casks", "cask"},
+		{"appStore.apps", "App Store app"},
+	}
+
+	seen := make(map[string]map[string]*yaml.Node)
+	for _, list := range lists {
+		seen[list.key] = make(map[string]*yaml.Node)
+		for _, item := range scalarItems(root, list.key) {
+			if first, ok := seen[list.key][item.Value]; ok {
+				*problems = append(*problems, newProblem(item, "duplicate %s %q%s", list.kind, item.Value, listedAt(" (first listed at line %d)", first)))
+				continue
+			}
+			seen[list.key][item.Value] = item
+		}
+	}
+
+	for _, item := range scalarItems(root, "casks") {
+		if formula, ok := seen["formulae"][item.Value]; ok && seen["casks"][item.Value] == item {
+			*problems = append(*problems, newProblem(item, "%q is listed as both a cask and a formula%s", item.Value, listedAt(" (line %d)", formula)))
+		}
+	}
+}
+
+// checkTemplates reports values whose template syntax cannot be parsed.
+// Undefined variables are only detected when the config is rendered, since
+// they may be supplied with --var or the environment.
+func checkTemplates(node *yaml.Node, problems *[]configProblem) {
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "{{") {
+		if _, err := template.New("value").Parse(node.Value); err != nil {
+			*problems = append(*problems, newProblem(node, "invalid template: %v", err))
+		}
+	}
+	for _, child := range node.Content {
+		checkTemplates(child, problems)
+	}
+}
+
+// checkDockReplace reports dock.replace entries missing the app to add or
+// the item to replace.
+func checkDockReplace(root *yaml.Node, problems *[]configProblem) {
+	dock := mappingValue(root, "dock")
+	if dock == nil {
+		return
+	}
+	replace := mappingValue(dock, "replace")
+	if replace == nil || replace.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, item := range replace.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		for _, key := range []string{"app", "replacing"} {
+			if value := mappingValue(item, key); value == nil || strings.TrimSpace(value.Value) == "" {
+				*problems = append(*problems, newProblem(item, "dock.replace entry needs a non-empty %q", key))
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigValid(t *testing.T) {
+	content := `
+version: 3
+casks:
+  - google-chrome
+formulae:
+  - git
+appStore:
+  apps:
+    - 409201541
+dock:
+  replace:
+    - app: /Applications/Google Chrome.app
+      replacing: Safari
+`
+	if problems := validateConfig([]byte(content), formatYAML); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateConfigShippedConfig(t *testing.T) {
+	data, err := os.ReadFile("deploy_config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := validateConfig(data, formatYAML); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateConfigProblems(t *testing.T) {
+	content := `version: 3
+casks:
+  - docker
+  - docker
+formula:
+  - git
+formulae:
+  - docker
+dock:
+  replace:
+    - app: /Applications/Arc.app
+    - app: /Applications/Arc.app
+      replacing: Safari
+  add: /Applications/Warp.app
+`
+	want := []string{
+		`4:5: duplicate cask "docker" (first listed at line 3)`,
+		`3:5: "docker" is listed as both a cask and a formula (line 8)`,
+		`5:1: unknown key "formula" in config (did you mean "formulae"?)`,
+		`11:7: dock.replace entry needs a non-empty "replacing"`,
+		`14:8: dock.add must be a list`,
+	}
+
+	problems := validateConfig([]byte(content), formatYAML)
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %d: %v", len(want), len(problems), problems)
+	}
+
+	for _, w := range want {
+		found := false
+		for _, p := range problems {
+			if strings.HasPrefix(p.String(), w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected problem %q, got %v", w, problems)
+		}
+	}
+}
+
+func TestValidateConfigSyntaxError(t *testing.T) {
+	problems := validateConfig([]byte("casks:\n\t- arc\n"), formatYAML)
+	if len(problems) != 1 {
+		t.Fatalf("Expected 1 problem, got %v", problems)
+	}
+	if problems[0].Line != 2 {
+		t.Errorf("Expected problem on line 2, got %v", problems[0])
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// cltPlaceholder makes softwareupdate offer the Command Line Tools, which it
+// otherwise only does when a tool asks for them through the GUI prompt.
+var cltPlaceholder = "/tmp/.com.apple.dt.CommandLineTools.installondemand.in-progress"
+
+// commandLineToolsPath returns the active developer directory, or "" if the
+// Command Line Tools are not installed. xcode-select keeps printing the path
+// after the tools are deleted, so the directory must exist too.
+func commandLineToolsPath(r Runner) string {
+	out, err := r.Output("xcode-select", "-p")
+	if err != nil {
+		return ""
+	}
+	path := strings.TrimSpace(out)
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return ""
+	}
+	return path
+}
+
+// commandLineToolsLabel returns the label of the newest Command Line Tools
+// update in `softwareupdate -l` output.
+func commandLineToolsLabel(output string) string {
+	var label, version string
+	for _, update := range parseSoftwareUpdates(output) {
+		if !strings.HasPrefix(update.Label, "Command Line Tools") {
+			continue
+		}
+		if label == "" || compareVersions(update.Version, version) > 0 {
+			label, version = update.Label, update.Version
+		}
+	}
+	return label
+}
+
+// compareVersions compares dotted numeric versions such as 15.1 and 14.3.1.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			fmt.Sscan(as[i], &x)
+		}
+		if i < len(bs) {
+			fmt.Sscan(bs[i], &y)
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// installCommandLineTools installs the Xcode Command Line Tools without the
+// GUI dialog the Homebrew installer would otherwise trigger, and checks that
+// xcode-select finds them afterwards.
+func installCommandLineTools(r Runner) error {
+	clearScreen()
+	fmt.Println("Checking for the Xcode Command Line Tools...")
+	if path := commandLineToolsPath(r); path != "" {
+		fmt.Printf("The Command Line Tools are already installed in %s.\n", path)
+		return nil
+	}
+
+	if err := os.WriteFile(cltPlaceholder, nil, 0644); err != nil {
+		return fmt.Errorf("creating %s: %v", cltPlaceholder, err)
+	}
+	defer os.Remove(cltPlaceholder)
+
+	out, err := r.Output("softwareupdate", "-l")
+	if err != nil {
+		return fmt.Errorf("listing updates: %v", err)
+	}
+	label := commandLineToolsLabel(out)
+	if label == "" {
+		return fmt.Errorf("softwareupdate does not offer the Command Line Tools")
+	}
+
+	fmt.Printf("Installing %s...\n", label)
+	if err := r.Run("sudo", "softwareupdate", "-i", label); err != nil {
+		return fmt.Errorf("installing %s: %v", label, err)
+	}
+
+	path := commandLineToolsPath(r)
+	if path == "" {
+		return fmt.Errorf("xcode-select does not find the Command Line Tools after installing %s", label)
+	}
+	fmt.Printf("The Command Line Tools are installed in %s.\n", path)
+	return nil
+}
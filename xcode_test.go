@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// placeholderRunner records whether the CLT placeholder file existed when
+// softwareupdate listed the updates.
+type placeholderRunner struct {
+	*sequenceRunner
+	sawPlaceholder bool
+}
+
+func (p *placeholderRunner) Output(name string, args ...string) (string, error) {
+	if name == "softwareupdate" {
+		_, err := os.Stat(cltPlaceholder)
+		p.sawPlaceholder = err == nil
+	}
+	return p.sequenceRunner.Output(name, args...)
+}
+
+const cltUpdates = `Software Update Tool
+
+Finding available software
+Software Update found the following new or updated software:
+* Label: Command Line Tools for Xcode-14.3
+	Title: Command Line Tools for Xcode, Version: 14.3, Size: 711000KiB, Recommended: YES,
+* Label: Command Line Tools for Xcode-15.1
+	Title: Command Line Tools for Xcode, Version: 15.1, Size: 735000KiB, Recommended: YES,
+* Label: Command Line Tools for Xcode-15.0
+	Title: Command Line Tools for Xcode, Version: 15.0, Size: 703205KiB, Recommended: YES,
+`
+
+// useCLTPlaceholder points cltPlaceholder into a temporary directory for the
+// test.
+func useCLTPlaceholder(t *testing.T) {
+	old := cltPlaceholder
+	cltPlaceholder = filepath.Join(t.TempDir(), "placeholder")
+	t.Cleanup(func() { cltPlaceholder = old })
+}
+
+func TestInstallCommandLineTools(t *testing.T) {
+	useCLTPlaceholder(t)
+	developer := t.TempDir()
+
+	r := &placeholderRunner{sequenceRunner: &sequenceRunner{
+		fakeRunner: newFakeRunner(map[string]fakeResult{"softwareupdate -l": {output: cltUpdates}}),
+		outputs:    map[string][]string{"xcode-select -p": {"", developer + "\n"}},
+	}}
+	if err := installCommandLineTools(r); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !r.sawPlaceholder {
+		t.Errorf("Expected the placeholder file to exist while listing updates")
+	}
+	if _, err := os.Stat(cltPlaceholder); !os.IsNotExist(err) {
+		t.Errorf("Expected the placeholder file to be removed, got %v", err)
+	}
+	if !r.called("sudo softwareupdate -i Command Line Tools for Xcode-15.1") {
+		t.Errorf("Expected the newest CLT to be installed, got %v", r.calls)
+	}
+}
+
+func TestInstallCommandLineToolsAlreadyInstalled(t *testing.T) {
+	r := newFakeRunner(map[string]fakeResult{"xcode-select -p": {output: t.TempDir() + "\n"}})
+	if err := installCommandLineTools(r); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(r.calls) != 1 {
+		t.Errorf("Expected only the check to run, got %v", r.calls)
+	}
+}
+
+func TestInstallCommandLineToolsFailures(t *testing.T) {
+	useCLTPlaceholder(t)
+
+	// xcode-select still names a directory that was deleted, and nothing is
+	// offered.
+	r := newFakeRunner(map[string]fakeResult{
+		"xcode-select -p":   {output: "/Library/Developer/CommandLineTools-deleted\n"},
+		"softwareupdate -l": {output: "No new software available.\n"},
+	})
+	if err := installCommandLineTools(r); err == nil || !strings.Contains(err.Error(), "does not offer") {
+		t.Errorf("Expected an error about the missing update, got %v", err)
+	}
+
+	// The install reports success but the tools are still missing.
+	r = newFakeRunner(map[string]fakeResult{"softwareupdate -l": {output: cltUpdates}})
+	if err := installCommandLineTools(r); err == nil || !strings.Contains(err.Error(), "after installing") {
+		t.Errorf("Expected the verification to fail, got %v", err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"15.1", "15.0", 1},
+		{"14.3.1", "14.3", 1},
+		{"9.4", "10.0", -1},
+		{"15.0", "15.0", 0},
+	}
+	for _, test := range tests {
+		if got := compareVersions(test.a, test.b); got != test.want {
+			t.Errorf("compareVersions(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}